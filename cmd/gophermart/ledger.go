@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/config"
+	"github.com/zklevsha/go-musthave-diploma/internal/db"
+	"github.com/zklevsha/go-musthave-diploma/internal/money"
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+const ledgerUsage = "usage: gophermart [flags] ledger " +
+	"show <userid>|adjust <userid> <amount> <reason>|reverse <userid> <entryid>"
+
+// runLedger handles 'ledger' subcommand (manual balance corrections)
+func runLedger(ctx context.Context, config config.ServerConfig, args []string) {
+	if len(args) < 2 {
+		log.Fatal(ledgerUsage)
+	}
+	if config.DSN == "" {
+		log.Fatal("CRITICAL ledger requires database connection string")
+	}
+	userid, err := strconv.Atoi(args[1])
+	if err != nil {
+		log.Fatalf("ERROR bad userid %q. %s", args[1], ledgerUsage)
+	}
+
+	s := &db.DBConnector{DSN: config.DSN, Ctx: ctx}
+	err = s.Init()
+	if err != nil {
+		log.Fatalf("CRITICAL failed to init connection to database: %s", err.Error())
+	}
+	defer s.Close()
+
+	var entry structs.LedgerEntry
+	switch {
+	case args[0] == "show" && len(args) == 2:
+		err = printLedger(s, userid)
+	case args[0] == "adjust" && len(args) >= 4:
+		amount, perr := money.Parse(args[2])
+		if perr != nil {
+			log.Fatalf("ERROR bad amount %q. %s", args[2], ledgerUsage)
+		}
+		entry, err = s.AddLedgerEntry(userid, structs.LedgerEntry{
+			Type:      structs.LedgerAdjustment,
+			Amount:    amount,
+			Reference: strings.Join(args[3:], " ")})
+	case args[0] == "reverse" && len(args) == 3:
+		entry, err = s.AddLedgerEntry(userid, structs.LedgerEntry{
+			Type:      structs.LedgerReversal,
+			Reference: args[2]})
+	default:
+		log.Fatal(ledgerUsage)
+	}
+	if err != nil {
+		log.Fatalf("ERROR ledger %s failed: %s", args[0], err.Error())
+	}
+	if entry.ID != 0 {
+		log.Printf("INFO ledger entry %d was added (type: %s, amount: %s, reference: %s)",
+			entry.ID, entry.Type, entry.Amount, entry.Reference)
+	}
+}
+
+func printLedger(s *db.DBConnector, userid int) error {
+	entries, err := s.GetLedger(userid)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTYPE\tAMOUNT\tREFERENCE\tCREATED AT")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", e.ID, e.Type, e.Amount, e.Reference, e.CreatedAt)
+	}
+	return w.Flush()
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,9 +12,13 @@ import (
 	"sync"
 	"syscall"
 
+	"github.com/zklevsha/go-musthave-diploma/internal/accrual"
 	"github.com/zklevsha/go-musthave-diploma/internal/config"
 	"github.com/zklevsha/go-musthave-diploma/internal/db"
 	"github.com/zklevsha/go-musthave-diploma/internal/handler"
+	"github.com/zklevsha/go-musthave-diploma/internal/interfaces"
+	"github.com/zklevsha/go-musthave-diploma/internal/jwt"
+	"github.com/zklevsha/go-musthave-diploma/internal/memstorage"
 	"github.com/zklevsha/go-musthave-diploma/internal/processor"
 )
 
@@ -31,27 +36,87 @@ func main() {
 	log.Printf("INFO main server config: RunAddr: %s, AccrualURL: %s, DSN: %s",
 		config.RunAddr, config.AccrualURL, dsnLog)
 
+	// subcommands
+	if args := flag.Args(); len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			runMigrate(ctx, config, args[1:])
+		case "ledger":
+			runLedger(ctx, config, args[1:])
+		case "orders":
+			runOrders(ctx, config, args[1:])
+		default:
+			log.Fatalf("CRITICAL unknown command %q", args[0])
+		}
+		cancel()
+		return
+	}
+
+	// keyring is validated before anything is started
+	keyring, err := newKeyring(config)
+	if err != nil {
+		log.Fatalf("CRITICAL failed to create jwt keyring: %s", err.Error())
+	}
+	log.Printf("INFO main jwt tokens are signed with key %q", keyring.ActiveKey())
+
 	// initiazing storage
-	s := &db.DBConnector{DSN: config.DSN, Ctx: ctx}
-	err := s.Init()
+	// storage outlives processor, so it can release claimed orders on shutdown
+	storageCtx, storageCancel := context.WithCancel(context.Background())
+	defer storageCancel()
+	var s interfaces.Storage
+	if config.DSN != "" {
+		s = &db.DBConnector{DSN: config.DSN, Ctx: storageCtx}
+	} else {
+		log.Println("WARN main database is not configured. Using in-memory storage")
+		s = &memstorage.MemStorage{}
+	}
+	err = s.Init()
 	if err != nil {
-		log.Panicf("CRITICAL failed to init connection to database: %s", err.Error())
+		log.Panicf("CRITICAL failed to init storage: %s", err.Error())
 	}
 	defer s.Close()
 
+	// accrual system client
+	accrualClient, err := accrual.NewHTTPClient(accrual.Config{
+		URL:                config.AccrualURL,
+		Timeout:            config.AccrualTimeout,
+		Gzip:               config.AccrualGzip,
+		CACert:             config.AccrualCACert,
+		ClientCert:         config.AccrualClientCert,
+		ClientKey:          config.AccrualClientKey,
+		APIKey:             config.AccrualAPIKey,
+		MaxConns:           config.AccrualWorkers,
+		BreakerThreshold:   config.AccrualBreakerThreshold,
+		BreakerCooldown:    config.AccrualBreakerCooldown,
+		BreakerMaxCooldown: config.AccrualBreakerMaxCooldown,
+	})
+	if err != nil {
+		log.Panicf("CRITICAL failed to init accrual system client: %s", err.Error())
+	}
+
 	//Starting order`s proccessor
-	p := processor.Processor{
-		Delay:   config.AccrualDelay,
-		Ctx:     ctx,
-		Wg:      &wg,
-		Storage: s,
-		Accrual: config.AccrualURL,
+	p := &processor.Processor{
+		Delay:          config.AccrualDelay,
+		Ctx:            ctx,
+		Wg:             &wg,
+		Storage:        s,
+		Accrual:        accrualClient,
+		Workers:        config.AccrualWorkers,
+		RateLimit:      config.AccrualRateLimit,
+		MaxAttempts:    config.AccrualMaxAttempts,
+		MaxBackoff:     config.AccrualMaxBackoff,
+		RequestTimeout: config.AccrualTimeout,
+	}
+	if config.AccrualCallbackKey != "" {
+		log.Printf("INFO main push mode is enabled, orders without callback will be polled after %s",
+			config.AccrualCallbackTimeout)
+		p.CallbackTimeout = config.AccrualCallbackTimeout
 	}
 	wg.Add(1)
 	go p.Start()
 
 	// Starting web server
-	handler := handler.GetHandler(config, ctx, s)
+	handler := handler.GetHandler(config, ctx, s, keyring)
 	fmt.Printf("INFO main starting web server at %s\n", config.RunAddr)
 
 	srv := &http.Server{
@@ -79,3 +144,29 @@ func main() {
 	wg.Wait()
 	log.Print("INFO server exited properly")
 }
+
+// newKeyring creates keyring of jwt signing keys.
+// Jwt key is the only signing key if keyring file is not set (server key if jwt key is not set, deprecated).
+// Otherwise server key is used only to verify legacy tokens (without kid)
+func newKeyring(c config.ServerConfig) (*jwt.Keyring, error) {
+	legacy := ""
+	if c.JWTAcceptLegacy {
+		legacy = c.Key
+	}
+	if c.JWTKeysFile == "" {
+		secret := c.JWTKey
+		if secret == "" {
+			// DEPRECATED: fallback is kept for one release, so existing deployments keep working
+			log.Println("WARN main jwt key is not set, server key is used to sign tokens. " +
+				"This is deprecated and will be removed in the next release: " +
+				"set 'JWT_KEY' enviroment variable or '-jwt-key' flag (or keyring file via 'JWT_KEYS_FILE' or '-jwt-keys')")
+			secret = c.Key
+		}
+		active := c.JWTActiveKey
+		if active == "" {
+			active = jwt.DefaultKeyID
+		}
+		return jwt.NewKeyring([]jwt.Key{{ID: jwt.DefaultKeyID, Secret: secret}}, active, legacy)
+	}
+	return jwt.LoadKeyring(c.JWTKeysFile, c.JWTActiveKey, legacy)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/config"
+	"github.com/zklevsha/go-musthave-diploma/internal/db"
+)
+
+const migrateUsage = "usage: gophermart [flags] migrate up|down [steps]|status"
+
+// runMigrate handles 'migrate' subcommand
+func runMigrate(ctx context.Context, config config.ServerConfig, args []string) {
+	if len(args) == 0 {
+		log.Fatal(migrateUsage)
+	}
+	if config.DSN == "" {
+		log.Fatal("CRITICAL migrate requires database connection string")
+	}
+
+	s := &db.DBConnector{DSN: config.DSN, Ctx: ctx, SkipMigrations: true}
+	err := s.Init()
+	if err != nil {
+		log.Fatalf("CRITICAL failed to init connection to database: %s", err.Error())
+	}
+	defer s.Close()
+
+	switch args[0] {
+	case "up":
+		err = s.MigrateUp()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatalf("ERROR bad number of steps %q. %s", args[1], migrateUsage)
+			}
+		}
+		err = s.MigrateDown(steps)
+	case "status":
+		err = printMigrationStatus(s)
+	default:
+		log.Fatalf("ERROR unknown migrate command %q. %s", args[0], migrateUsage)
+	}
+	if err != nil {
+		log.Fatalf("ERROR migrate %s failed: %s", args[0], err.Error())
+	}
+}
+
+func printMigrationStatus(s *db.DBConnector) error {
+	status, err := s.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, m := range status {
+		appliedAt := "pending"
+		if m.Applied {
+			appliedAt = m.AppliedAt.Format("2006-01-02T15:04:05-07:00")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", m.Version, m.Name, appliedAt)
+	}
+	return w.Flush()
+}
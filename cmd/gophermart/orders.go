@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/config"
+	"github.com/zklevsha/go-musthave-diploma/internal/db"
+)
+
+const ordersUsage = "usage: gophermart [flags] orders dead|requeue <orderid>|requeue all"
+
+// runOrders handles 'orders' subcommand (dead-lettered orders management)
+func runOrders(ctx context.Context, config config.ServerConfig, args []string) {
+	if len(args) < 1 {
+		log.Fatal(ordersUsage)
+	}
+	if config.DSN == "" {
+		log.Fatal("CRITICAL orders requires database connection string")
+	}
+
+	s := &db.DBConnector{DSN: config.DSN, Ctx: ctx}
+	err := s.Init()
+	if err != nil {
+		log.Fatalf("CRITICAL failed to init connection to database: %s", err.Error())
+	}
+	defer s.Close()
+
+	switch {
+	case args[0] == "dead" && len(args) == 1:
+		err = printDeadOrders(s)
+	case args[0] == "requeue" && len(args) == 2 && args[1] == "all":
+		err = requeueAll(s)
+	case args[0] == "requeue" && len(args) == 2:
+		id, perr := strconv.Atoi(args[1])
+		if perr != nil {
+			log.Fatalf("ERROR bad orderid %q. %s", args[1], ordersUsage)
+		}
+		err = requeue(s, id)
+	default:
+		log.Fatal(ordersUsage)
+	}
+	if err != nil {
+		log.Fatalf("ERROR orders %s failed: %s", args[0], err.Error())
+	}
+}
+
+func printDeadOrders(s *db.DBConnector) error {
+	orders, err := s.GetDeadOrders()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NUMBER\tSTATUS\tATTEMPTS\tDEAD AT\tLAST ERROR")
+	for _, o := range orders {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", o.Number, o.Status, o.Attempts, o.DeadAt, o.LastError)
+	}
+	return w.Flush()
+}
+
+func requeue(s *db.DBConnector, id int) error {
+	rowsAffected, err := s.RequeueOrder(id)
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("order %d does not exist or is not dead-lettered", id)
+	}
+	log.Printf("INFO order %d was requeued", id)
+	return nil
+}
+
+func requeueAll(s *db.DBConnector) error {
+	orders, err := s.GetDeadOrders()
+	if err != nil {
+		return err
+	}
+	for _, o := range orders {
+		id, err := strconv.Atoi(o.Number)
+		if err != nil {
+			return fmt.Errorf("bad order number %s: %s", o.Number, err.Error())
+		}
+		if err := requeue(s, id); err != nil {
+			return err
+		}
+	}
+	log.Printf("INFO %d orders were requeued", len(orders))
+	return nil
+}
@@ -9,7 +9,10 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/zklevsha/go-musthave-diploma/internal/accrual"
+	"github.com/zklevsha/go-musthave-diploma/internal/cassette"
 	"github.com/zklevsha/go-musthave-diploma/internal/config"
+	"github.com/zklevsha/go-musthave-diploma/internal/emulator"
 	"github.com/zklevsha/go-musthave-diploma/internal/handler"
 )
 
@@ -19,15 +22,70 @@ func main() {
 	log.Println("INFO main starting accrual server")
 	config := config.GetAccrualConfig()
 
-	log.Printf("INFO main server config: RunAddr: %s", config.RunAddr)
+	log.Printf("INFO main server config: RunAddr: %s, ScenarioFile: %s, RateLimit: %d",
+		config.RunAddr, config.ScenarioFile, config.RateLimit)
+
+	e := emulator.New()
+	if config.ScenarioFile != "" {
+		if err := e.LoadScenarioFile(config.ScenarioFile); err != nil {
+			log.Fatalf("CRITICAL failed to load scenarios: %s", err.Error())
+		}
+	}
+
+	faults := emulator.Faults{
+		Latency:         config.FaultLatency,
+		TooManyRequests: config.FaultTooManyRequests,
+		RetryAfter:      config.FaultRetryAfter.String(),
+		ServerError:     config.FaultServerError,
+		ConnReset:       config.FaultConnReset,
+		Truncated:       config.FaultTruncated,
+		Malformed:       config.FaultMalformed,
+	}
+	if err := faults.Init(); err != nil {
+		log.Fatalf("CRITICAL bad fault injection config: %s", err.Error())
+	}
+	e.SetFaults(faults)
+	e.SetRateLimit(config.RateLimit)
 
 	// Starting web server
-	handler := handler.AccGetHandler(config, ctx)
+	var h http.Handler
+	switch {
+	case config.ReplayFile != "" && config.Upstream != "":
+		log.Fatal("CRITICAL replay and record modes can`t be used together")
+	case (config.Upstream == "") != (config.RecordFile == ""):
+		log.Fatal("CRITICAL both upstream URL and record file must be set in record mode")
+	case config.ReplayFile != "":
+		player, err := cassette.Load(config.ReplayFile)
+		if err != nil {
+			log.Fatalf("CRITICAL failed to load cassette: %s", err.Error())
+		}
+		log.Printf("INFO main replaying %d responses from %s", player.Size(), config.ReplayFile)
+		h = handler.AccReplayHandler(player)
+	case config.Upstream != "":
+		recorder, err := cassette.NewRecorder(config.RecordFile)
+		if err != nil {
+			log.Fatalf("CRITICAL failed to create cassette: %s", err.Error())
+		}
+		defer recorder.Close()
+		log.Printf("INFO main proxying requests to %s, recording to %s", config.Upstream, config.RecordFile)
+		h, err = handler.AccRecordHandler(accrual.Config{
+			URL:        config.Upstream,
+			Timeout:    config.UpstreamTimeout,
+			CACert:     config.UpstreamCACert,
+			ClientCert: config.UpstreamClientCert,
+			ClientKey:  config.UpstreamClientKey,
+		}, recorder)
+		if err != nil {
+			log.Fatalf("CRITICAL failed to create upstream client: %s", err.Error())
+		}
+	default:
+		h = handler.AccGetHandler(config, ctx, e)
+	}
 	fmt.Printf("INFO main starting web server at %s\n", config.RunAddr)
 
 	srv := &http.Server{
 		Addr:    config.RunAddr,
-		Handler: handler,
+		Handler: h,
 	}
 
 	done := make(chan os.Signal, 1)
@@ -0,0 +1,95 @@
+package accrual
+
+import (
+	"sync"
+	"time"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+// breaker stops requests to accrual system when it is unavailable.
+// It opens after threshold consecutive failures for cooldown, which doubles
+// each time a probe request fails (up to maxCooldown). Successful request closes it.
+// When cooldown is over breaker is half-open: only one probe request is allowed at a time
+type breaker struct {
+	mu          sync.Mutex
+	threshold   int
+	cooldown    time.Duration
+	maxCooldown time.Duration
+	failures    int
+	opens       int
+	openUntil   time.Time
+	// probing is set while probe request of half-open breaker is in flight
+	probing bool
+}
+
+func newBreaker(threshold int, cooldown, maxCooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown, maxCooldown: maxCooldown}
+}
+
+// Allow reports whether request can be sent. Request allowed by half-open breaker
+// is a probe: its result must be registered by Failure, Success or Cancel
+func (b *breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// Cancel registers request which result says nothing about accrual system (e.g. it was canceled)
+func (b *breaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// Failure registers failed request.
+// Returns time until which breaker is open (zero if it is closed)
+func (b *breaker) Failure() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.probing = false
+	if b.failures < b.threshold || time.Now().Before(b.openUntil) {
+		return time.Time{}
+	}
+	cooldown := b.cooldown
+	for i := 0; i < b.opens && (b.maxCooldown <= 0 || cooldown < b.maxCooldown); i++ {
+		cooldown *= 2
+	}
+	if b.maxCooldown > 0 && cooldown > b.maxCooldown {
+		cooldown = b.maxCooldown
+	}
+	b.opens++
+	b.openUntil = time.Now().Add(cooldown)
+	return b.openUntil
+}
+
+// Success registers successful request and closes the breaker
+func (b *breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.opens = 0
+	b.probing = false
+	b.openUntil = time.Time{}
+}
+
+func (b *breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case b.failures < b.threshold:
+		return structs.BreakerClosed
+	case time.Now().Before(b.openUntil):
+		return structs.BreakerOpen
+	default:
+		return structs.BreakerHalfOpen
+	}
+}
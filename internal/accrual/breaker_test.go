@@ -0,0 +1,35 @@
+package accrual
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+func TestBreakerHalfOpenSingleProbe(t *testing.T) {
+	b := newBreaker(1, time.Millisecond, time.Millisecond)
+	if until := b.Failure(); until.IsZero() {
+		t.Fatal("breaker is not opened")
+	}
+	if b.Allow() {
+		t.Fatal("open breaker allows request")
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	if !b.Allow() {
+		t.Fatal("half-open breaker does not allow probe")
+	}
+	if b.Allow() {
+		t.Fatal("half-open breaker allows second probe")
+	}
+	// canceled probe lets another one through
+	b.Cancel()
+	if !b.Allow() {
+		t.Fatal("half-open breaker does not allow probe after canceled one")
+	}
+	b.Success()
+	if b.State() != structs.BreakerClosed || !b.Allow() || !b.Allow() {
+		t.Fatalf("breaker is not closed after successful probe, state: %s", b.State())
+	}
+}
@@ -0,0 +1,167 @@
+package accrual
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+// APIKeyHeader is a header used to send API key to accrual system
+const APIKeyHeader = "X-Api-Key"
+
+type Config struct {
+	URL string
+	// Timeout is a timeout of a single request (0 - no timeout)
+	Timeout time.Duration
+	// Gzip enables gzip compression of responses
+	Gzip bool
+	// CACert is a path to CA certificate of accrual system (PEM)
+	CACert string
+	// ClientCert and ClientKey are paths to client certificate and key (PEM)
+	ClientCert string
+	ClientKey  string
+	APIKey     string
+	// MaxConns is a maximum number of connections to accrual system (0 - no limit)
+	MaxConns int
+	// BreakerThreshold is a number of consecutive failures which opens circuit breaker
+	BreakerThreshold int
+	// BreakerCooldown is a time circuit breaker stays open,
+	// it doubles on each failed probe up to BreakerMaxCooldown
+	BreakerCooldown    time.Duration
+	BreakerMaxCooldown time.Duration
+}
+
+// HTTPClient is an implementation of interfaces.AccrualClient
+type HTTPClient struct {
+	url     string
+	apiKey  string
+	client  *http.Client
+	breaker *breaker
+}
+
+func NewHTTPClient(c Config) (*HTTPClient, error) {
+	client, err := NewClient(c)
+	if err != nil {
+		return nil, err
+	}
+	return &HTTPClient{
+		url:     c.URL,
+		apiKey:  c.APIKey,
+		client:  client,
+		breaker: newBreaker(c.BreakerThreshold, c.BreakerCooldown, c.BreakerMaxCooldown),
+	}, nil
+}
+
+// NewClient returns http client configured to connect to accrual system
+// (timeout, TLS, compression and connection limits of c)
+func NewClient(c Config) (*http.Client, error) {
+	tlsConfig, err := getTLSConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.DisableCompression = !c.Gzip
+	transport.MaxConnsPerHost = c.MaxConns
+	transport.MaxIdleConnsPerHost = c.MaxConns
+	return &http.Client{Transport: transport, Timeout: c.Timeout}, nil
+}
+
+func getTLSConfig(c Config) (*tls.Config, error) {
+	if c.CACert == "" && c.ClientCert == "" && c.ClientKey == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.CACert != "" {
+		pem, err := ioutil.ReadFile(c.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %s", err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("failed to parse CA certificate %s", c.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.ClientCert != "" || c.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %s", err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func (c *HTTPClient) State() string {
+	return c.breaker.State()
+}
+
+func (c *HTTPClient) GetOrder(ctx context.Context, orderID int) (structs.Order, error) {
+	if !c.breaker.Allow() {
+		return structs.Order{}, fmt.Errorf("%w: circuit breaker is open", structs.ErrAccrualUnavailable)
+	}
+	order, err := c.getOrder(ctx, orderID)
+	switch {
+	case ctx.Err() != nil:
+		// request was canceled by caller, it says nothing about accrual system
+		c.breaker.Cancel()
+	case errors.Is(err, structs.ErrAccrualUnavailable):
+		if until := c.breaker.Failure(); !until.IsZero() {
+			log.Printf("WARN accrual system is unavailable, circuit breaker is open until %s",
+				until.Format(time.RFC3339))
+		}
+	default:
+		c.breaker.Success()
+	}
+	return order, err
+}
+
+func (c *HTTPClient) getOrder(ctx context.Context, orderID int) (structs.Order, error) {
+	url := fmt.Sprintf("%s/api/orders/%d", c.url, orderID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return structs.Order{}, err
+	}
+	if c.apiKey != "" {
+		req.Header.Set(APIKeyHeader, c.apiKey)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return structs.Order{}, fmt.Errorf("%w: %s", structs.ErrAccrualUnavailable, err.Error())
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return structs.Order{}, fmt.Errorf("%w: failed to read response: %s",
+			structs.ErrAccrualUnavailable, err.Error())
+	}
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusNoContent:
+		return structs.Order{}, structs.ErrOrderNotRegistered
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return structs.Order{}, parseRateLimit(resp.Header, body, time.Now())
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return structs.Order{}, fmt.Errorf("%w: response status %d",
+			structs.ErrAccrualUnavailable, resp.StatusCode)
+	default:
+		return structs.Order{}, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	var order structs.Order
+	err = json.Unmarshal(body, &order)
+	if err != nil {
+		return structs.Order{}, err
+	}
+	order.Raw = body
+	return order, nil
+}
@@ -0,0 +1,120 @@
+package accrual_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/accrual"
+	"github.com/zklevsha/go-musthave-diploma/internal/config"
+	"github.com/zklevsha/go-musthave-diploma/internal/emulator"
+	"github.com/zklevsha/go-musthave-diploma/internal/handler"
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+// newTestClient creates client of mock accrual server running scenarios
+// (no scenarios - order is not registered)
+func newTestClient(t *testing.T, scenarios string) (*accrual.HTTPClient, *int64) {
+	t.Helper()
+	e := emulator.New()
+	if scenarios != "" {
+		s, err := emulator.ParseScenarios([]byte(scenarios))
+		if err != nil {
+			t.Fatalf("failed to parse scenarios: %s", err.Error())
+		}
+		e.SetScenarios(s)
+	}
+	accrualHandler := handler.AccGetHandler(config.AccrualConfig{}, context.Background(), e)
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		accrualHandler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := accrual.NewHTTPClient(accrual.Config{
+		URL:                srv.URL,
+		Timeout:            time.Second,
+		BreakerThreshold:   3,
+		BreakerCooldown:    time.Minute,
+		BreakerMaxCooldown: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %s", err.Error())
+	}
+	return c, &hits
+}
+
+func TestGetOrderProcessed(t *testing.T) {
+	c, _ := newTestClient(t, `{"scenarios": [
+		{"order": "12345678903", "responses": [{"status": "PROCESSED", "accrual": 500}]}]}`)
+
+	order, err := c.GetOrder(context.Background(), 12345678903)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if order.Status != "PROCESSED" || order.Accrual == nil || order.Accrual.String() != "500" {
+		t.Errorf("unexpected order: %+v", order)
+	}
+	if len(order.Raw) == 0 {
+		t.Error("raw response is not set")
+	}
+}
+
+func TestGetOrderNotRegistered(t *testing.T) {
+	c, _ := newTestClient(t, "")
+
+	_, err := c.GetOrder(context.Background(), 12345678903)
+	if !errors.Is(err, structs.ErrOrderNotRegistered) {
+		t.Fatalf("want ErrOrderNotRegistered, got %v", err)
+	}
+	if c.State() != structs.BreakerClosed {
+		t.Errorf("204 must not open circuit breaker, state: %s", c.State())
+	}
+}
+
+func TestGetOrderOpensBreaker(t *testing.T) {
+	c, hits := newTestClient(t, `{"scenarios": [
+		{"order": "12345678903", "responses": [{"code": 500}]}]}`)
+
+	for i := 0; i < 3; i++ {
+		_, err := c.GetOrder(context.Background(), 12345678903)
+		if !errors.Is(err, structs.ErrAccrualUnavailable) {
+			t.Fatalf("request %d: want ErrAccrualUnavailable, got %v", i, err)
+		}
+	}
+	if c.State() != structs.BreakerOpen {
+		t.Fatalf("want open circuit breaker, got %s", c.State())
+	}
+
+	// open breaker does not send requests
+	_, err := c.GetOrder(context.Background(), 12345678903)
+	if !errors.Is(err, structs.ErrAccrualUnavailable) {
+		t.Fatalf("want ErrAccrualUnavailable, got %v", err)
+	}
+	if n := atomic.LoadInt64(hits); n != 3 {
+		t.Errorf("want 3 requests, got %d", n)
+	}
+}
+
+func TestGetOrderRateLimited(t *testing.T) {
+	c, _ := newTestClient(t, `{"scenarios": [
+		{"order": "12345678903", "responses": [
+			{"code": 429, "headers": {"Retry-After": "5"}, "body": "No more than 10 requests per minute allowed"}]}]}`)
+
+	_, err := c.GetOrder(context.Background(), 12345678903)
+	var rateErr *structs.RateLimitError
+	if !errors.As(err, &rateErr) {
+		t.Fatalf("want RateLimitError, got %v", err)
+	}
+	if rateErr.Limit != 10 {
+		t.Errorf("want limit 10, got %d", rateErr.Limit)
+	}
+	if c.State() != structs.BreakerClosed {
+		t.Errorf("429 must not open circuit breaker, state: %s", c.State())
+	}
+}
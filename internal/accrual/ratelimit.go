@@ -0,0 +1,44 @@
+package accrual
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+// retryAfterDef is used when accrual system does not send Retry-After header
+const retryAfterDef = time.Minute
+
+var rateLimitRe = regexp.MustCompile(`No more than (\d+) requests per minute`)
+
+// parseRateLimit builds structs.RateLimitError from accrual system 429 response
+func parseRateLimit(h http.Header, body []byte, now time.Time) *structs.RateLimitError {
+	e := &structs.RateLimitError{RetryAfter: parseRetryAfter(h.Get("Retry-After"), now)}
+	if m := rateLimitRe.FindSubmatch(body); m != nil {
+		limit, err := strconv.Atoi(string(m[1]))
+		if err == nil && limit > 0 {
+			e.Limit = limit
+		}
+	}
+	return e
+}
+
+// parseRetryAfter parses Retry-After value (delay in seconds or HTTP-date)
+func parseRetryAfter(v string, now time.Time) time.Time {
+	if v == "" {
+		return now.Add(retryAfterDef)
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		if seconds < 0 {
+			seconds = 0
+		}
+		return now.Add(time.Duration(seconds) * time.Second)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t
+	}
+	return now.Add(retryAfterDef)
+}
@@ -0,0 +1,160 @@
+// Package cassette records HTTP exchanges with accrual system and replays them.
+//
+// Cassette is a NDJSON file, each line is an Entry:
+//
+//	{"time":"2022-05-01T10:00:00Z","method":"GET","path":"/api/orders/12345678903",
+//	 "code":200,"headers":{"Content-Type":["application/json"]},
+//	 "body":"{\"order\":\"12345678903\",\"status\":\"PROCESSED\",\"accrual\":500}","duration":"15ms"}
+//
+// Replay serves recorded responses of the same method and path (query included)
+// in recorded order, the last one is repeated
+package cassette
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrNotRecorded = errors.New("response is not recorded")
+
+// Entry is a single recorded request/response pair
+type Entry struct {
+	Time        time.Time           `json:"time"`
+	Method      string              `json:"method"`
+	Path        string              `json:"path"`
+	RequestBody string              `json:"request_body,omitempty"`
+	Code        int                 `json:"code"`
+	Headers     map[string][]string `json:"headers,omitempty"`
+	Body        string              `json:"body"`
+	// Error is a transport error (Code is 0), it is replayed as connection reset
+	Error string `json:"error,omitempty"`
+	// Duration is a time upstream took to respond (time.Duration string)
+	Duration string `json:"duration"`
+	duration time.Duration
+}
+
+// GetDuration returns parsed Duration
+func (e Entry) GetDuration() time.Duration {
+	return e.duration
+}
+
+func key(method, path string) string {
+	return method + " " + path
+}
+
+// Recorder appends entries to cassette file
+type Recorder struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// NewRecorder opens cassette file for appending (file is created if not exists)
+func NewRecorder(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cassette: %s", err.Error())
+	}
+	return &Recorder{file: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Record writes entry to cassette. Entries are written immediately,
+// so cassette is usable even if recorder was not closed properly
+func (r *Recorder) Record(e Entry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(e); err != nil {
+		return fmt.Errorf("failed to write cassette entry: %s", err.Error())
+	}
+	return nil
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// Player serves recorded responses
+type Player struct {
+	mu      sync.Mutex
+	entries map[string][]Entry
+	// number of served entries per key
+	played map[string]int
+}
+
+// Load reads cassette file
+func Load(path string) (*Player, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cassette: %s", err.Error())
+	}
+	defer f.Close()
+	return read(f)
+}
+
+func read(r io.Reader) (*Player, error) {
+	p := &Player{entries: make(map[string][]Entry), played: make(map[string]int)}
+	s := bufio.NewScanner(r)
+	// recorded bodies may be larger than default token size
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for s.Scan() {
+		line++
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("failed to parse cassette line %d: %s", line, err.Error())
+		}
+		if e.Duration != "" {
+			d, err := time.ParseDuration(e.Duration)
+			if err != nil {
+				return nil, fmt.Errorf("bad duration on cassette line %d: %s", line, err.Error())
+			}
+			e.duration = d
+		}
+		k := key(e.Method, e.Path)
+		p.entries[k] = append(p.entries[k], e)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %s", err.Error())
+	}
+	return p, nil
+}
+
+// Next returns the next recorded response of request
+func (p *Player) Next(method, path string) (Entry, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	k := key(method, path)
+	entries := p.entries[k]
+	if len(entries) == 0 {
+		return Entry{}, fmt.Errorf("%w: %s", ErrNotRecorded, k)
+	}
+	i := p.played[k]
+	if i >= len(entries) {
+		i = len(entries) - 1
+	} else {
+		p.played[k]++
+	}
+	return entries[i], nil
+}
+
+// Size returns number of recorded entries
+func (p *Player) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, e := range p.entries {
+		n += len(e)
+	}
+	return n
+}
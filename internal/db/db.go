@@ -3,18 +3,22 @@ package db
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/zklevsha/go-musthave-diploma/internal/money"
 	"github.com/zklevsha/go-musthave-diploma/internal/structs"
 )
 
 type DBConnector struct {
-	DSN        string
-	Ctx        context.Context
-	Pool       *pgxpool.Pool
-	initalized bool
+	DSN  string
+	Ctx  context.Context
+	Pool *pgxpool.Pool
+	// SkipMigrations disables applying of pending migrations in Init()
+	SkipMigrations bool
+	initalized     bool
 }
 
 func (d *DBConnector) checkInit() error {
@@ -48,41 +52,81 @@ func (d *DBConnector) Register(login string, password string) (int, error) {
 		return -1, structs.ErrUserAlreadyExists
 	}
 
+	tx, err := conn.Begin(d.Ctx)
+	if err != nil {
+		return -1, fmt.Errorf("failed to begin transaction: %s", err.Error())
+	}
+	defer tx.Rollback(d.Ctx)
+
 	// adding new user
 	var id int
 	sql = `INSERT INTO users (login, password)
 		   VALUES($1, $2)
 		   RETURNING id;`
-	err = conn.QueryRow(d.Ctx, sql, login, password).Scan(&id)
+	err = tx.QueryRow(d.Ctx, sql, login, password).Scan(&id)
 	if err != nil {
 		return -1, fmt.Errorf("failed to create user id DB: %s", err.Error())
 	}
+
+	sql = `INSERT INTO balances (userid) VALUES($1);`
+	_, err = tx.Exec(d.Ctx, sql, id)
+	if err != nil {
+		return -1, fmt.Errorf("failed to create user balance: %s", err.Error())
+	}
+
+	err = tx.Commit(d.Ctx)
+	if err != nil {
+		return -1, fmt.Errorf("failed to commit transaction: %s", err.Error())
+	}
 	return id, nil
 }
 
-func (d *DBConnector) GetUserID(creds structs.Credentials) (int, error) {
+func (d *DBConnector) GetUser(login string) (structs.User, error) {
+	err := d.checkInit()
+	if err != nil {
+		return structs.User{}, err
+	}
+
 	conn, err := d.Pool.Acquire(d.Ctx)
 	if err != nil {
-		return -1, fmt.Errorf("failed to acquire connection: %s", err.Error())
+		return structs.User{}, fmt.Errorf("failed to acquire connection: %s", err.Error())
 	}
 	defer conn.Release()
-	var id int
-	var password string
+	user := structs.User{Login: login}
 	sql := `SELECT id, password FROM users WHERE login=$1;`
-	row := conn.QueryRow(d.Ctx, sql, creds.Login)
+	row := conn.QueryRow(d.Ctx, sql, login)
 
-	switch err := row.Scan(&id, &password); err {
+	switch err := row.Scan(&user.ID, &user.Password); err {
 	case pgx.ErrNoRows:
-		return -1, structs.ErrUserAuth
+		return structs.User{}, structs.ErrUserAuth
 	case nil:
-		return id, nil
+		return user, nil
 	default:
 		e := fmt.Errorf("unknown error while authenticating user: %s", err.Error())
-		return -1, e
+		return structs.User{}, e
 	}
 
 }
 
+func (d *DBConnector) SetUserPassword(id int, password string) (int64, error) {
+	err := d.checkInit()
+	if err != nil {
+		return -1, err
+	}
+	conn, err := d.Pool.Acquire(d.Ctx)
+	if err != nil {
+		return -1, fmt.Errorf("failed to acquire connection: %s", err.Error())
+	}
+	defer conn.Release()
+	sql := `UPDATE users SET password = $2 WHERE id = $1;`
+	res, err := conn.Exec(d.Ctx, sql, id, password)
+	if err != nil {
+		return -1, fmt.Errorf("failed exec sql: %s", err.Error())
+	}
+	count := res.RowsAffected()
+	return count, nil
+}
+
 func (d *DBConnector) Init() error {
 	if d.initalized {
 		return nil
@@ -92,9 +136,12 @@ func (d *DBConnector) Init() error {
 		return fmt.Errorf("unable to connect to database: %v", err)
 	}
 	d.Pool = p
-	err = d.CreateTables()
-	if err != nil {
-		return err
+	if !d.SkipMigrations {
+		err = d.MigrateUp()
+		if err != nil {
+			p.Close()
+			return err
+		}
 	}
 	d.initalized = true
 	return nil
@@ -140,137 +187,175 @@ func (d *DBConnector) CreateOrder(userid int, orderid int) (bool, error) {
 		return false, e
 	}
 
+	tx, err := conn.Begin(d.Ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %s", err.Error())
+	}
+	defer tx.Rollback(d.Ctx)
+
 	// creating new order
 	now := time.Now().Unix()
-	sql = `INSERT INTO orders (id, created_ts, userid)
-		   VALUES($1, $2, $3);`
-	_, err = conn.Exec(d.Ctx, sql, orderid, now, userid)
+	sql = `INSERT INTO orders (id, created_ts, updated_ts, userid)
+		   VALUES($1, $2, $2, $3);`
+	_, err = tx.Exec(d.Ctx, sql, orderid, now, userid)
+	if err != nil {
+		return false, err
+	}
+
+	event := structs.OrderEvent{To: "NEW", Source: structs.SourceUser}
+	err = insertOrderEvent(d.Ctx, tx, orderid, event)
 	if err != nil {
 		return false, err
 	}
+
+	// notification is delivered to listeners on commit
+	_, err = tx.Exec(d.Ctx, `SELECT pg_notify($1, $2);`, newOrdersChannel, strconv.Itoa(orderid))
+	if err != nil {
+		return false, fmt.Errorf("failed to notify about new order: %s", err.Error())
+	}
+
+	err = tx.Commit(d.Ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %s", err.Error())
+	}
 	return true, nil
 }
 
-func (d *DBConnector) GetOrders(userid int) ([]structs.Order, error) {
+func (d *DBConnector) GetOrders(userid int, filter structs.ListFilter) ([]structs.Order, *structs.Cursor, error) {
 	err := d.checkInit()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	conn, err := d.Pool.Acquire(d.Ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to acquire connection: %s", err.Error())
+		return nil, nil, fmt.Errorf("failed to acquire connection: %s", err.Error())
 	}
 	defer conn.Release()
 
-	sql := `SELECT id, status, accrual, created_ts 
+	sql := `SELECT id, status, accrual, created_ts
 			FROM orders
 			WHERE userid=$1`
-	rows, err := conn.Query(d.Ctx, sql, userid)
+	args := []interface{}{userid}
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		sql += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+	sql, args = applyListFilter(sql, args, "created_ts", "id", filter)
+	rows, err := conn.Query(d.Ctx, sql, args...)
 	if err != nil {
 		e := fmt.Errorf("failed to query orders table: %s", err.Error())
-		return nil, e
+		return nil, nil, e
 	}
 	defer rows.Close()
 
 	var orders []structs.Order
+	var cursors []structs.Cursor
 	for rows.Next() {
 		var orderNumber int
 		var status string
-		var accrual *float64
+		var accrual *money.Amount
 		var createdTS int64
 
 		if err := rows.Scan(&orderNumber, &status, &accrual, &createdTS); err != nil {
 			e := fmt.Errorf("failed to scan row from orders table: %s", err.Error())
-			return nil, e
+			return nil, nil, e
 		}
 		order := structs.Order{Number: fmt.Sprint(orderNumber),
 			Status:     status,
 			Accrual:    accrual,
 			UploadedAt: time.Unix(createdTS, 0).Format("2006-01-02T15:04:05-07:00")}
 		orders = append(orders, order)
+		cursors = append(cursors, structs.Cursor{TS: createdTS, ID: int64(orderNumber)})
 	}
 
 	if err := rows.Err(); err != nil {
 		e := fmt.Errorf("error(s) occured during orders table scanning: %s", err.Error())
-		return nil, e
+		return nil, nil, e
 	}
 
-	return orders, nil
+	if filter.Limit > 0 && len(orders) > filter.Limit {
+		return orders[:filter.Limit], &cursors[filter.Limit-1], nil
+	}
+	return orders, nil, nil
 }
 
-func (d *DBConnector) GetUnprocessedOrders() ([]int, error) {
+// SetOrderResult changes order status, records the transition in order history and,
+// if order is PROCESSED, sets its accrual and credits it to the user`s balance in the same transaction.
+// Setting the same status again does nothing. Final statuses (INVALID, PROCESSED)
+// can not be changed (structs.ErrOrderStatusFinal)
+func (d *DBConnector) SetOrderResult(id int, status string, accrual *money.Amount,
+	source string, payload []byte) (int64, error) {
 	err := d.checkInit()
 	if err != nil {
-		return nil, err
+		return -1, err
 	}
-
 	conn, err := d.Pool.Acquire(d.Ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to acquire connection: %s", err.Error())
+		return -1, fmt.Errorf("failed to acquire connection: %s", err.Error())
 	}
 	defer conn.Release()
 
-	sql := `SELECT id
-			FROM orders
-			WHERE status='NEW' OR status='PROCESSING'`
-	rows, err := conn.Query(d.Ctx, sql)
+	tx, err := conn.Begin(d.Ctx)
 	if err != nil {
-		e := fmt.Errorf("failed to query orders table: %s", err.Error())
-		return nil, e
-	}
-	defer rows.Close()
-
-	var orders []int
-	for rows.Next() {
-		var orderNumber int
-		if err := rows.Scan(&orderNumber); err != nil {
-			e := fmt.Errorf("failed to scan row from orders table: %s", err.Error())
-			return nil, e
-		}
-		orders = append(orders, orderNumber)
+		return -1, fmt.Errorf("failed to begin transaction: %s", err.Error())
 	}
+	defer tx.Rollback(d.Ctx)
 
-	if err := rows.Err(); err != nil {
-		e := fmt.Errorf("error(s) occured during orders table scanning: %s", err.Error())
-		return nil, e
+	var current string
+	var userid int
+	sql := `SELECT status, userid FROM orders WHERE id = $1 FOR UPDATE;`
+	err = tx.QueryRow(d.Ctx, sql, id).Scan(&current, &userid)
+	if err == pgx.ErrNoRows {
+		return 0, nil
 	}
-	return orders, nil
-}
-
-func (d *DBConnector) SetOrderStatus(id int, status string) (int64, error) {
-	err := d.checkInit()
 	if err != nil {
-		return -1, err
+		return -1, fmt.Errorf("failed to query orders table: %s", err.Error())
 	}
-	conn, err := d.Pool.Acquire(d.Ctx)
-	if err != nil {
-		return -1, fmt.Errorf("failed to acquire connection: %s", err.Error())
+	if current == status {
+		return 1, nil
 	}
-	defer conn.Release()
-	sql := `UPDATE orders SET status = $2 WHERE id = $1;`
-	res, err := conn.Exec(d.Ctx, sql, id, status)
+	if structs.IsFinalStatus(current) {
+		return -1, fmt.Errorf("%w (%s -> %s)", structs.ErrOrderStatusFinal, current, status)
+	}
+
+	// status change is a progress, so failed attempts are forgotten
+	sql = `UPDATE orders
+		   SET status = $2, updated_ts = $3, attempts = 0, next_attempt_ts = 0, last_error = NULL
+		   WHERE id = $1;`
+	res, err := tx.Exec(d.Ctx, sql, id, status, time.Now().Unix())
 	if err != nil {
 		return -1, fmt.Errorf("failed exec sql: %s", err.Error())
 	}
-	count := res.RowsAffected()
-	return count, nil
-}
 
-func (d *DBConnector) SetOrderAccrual(id int, accrual float64) (int64, error) {
-	err := d.checkInit()
+	event := structs.OrderEvent{From: current, To: status, Source: source,
+		Payload: structs.EventPayload(payload)}
+	err = insertOrderEvent(d.Ctx, tx, id, event)
 	if err != nil {
 		return -1, err
 	}
-	conn, err := d.Pool.Acquire(d.Ctx)
-	if err != nil {
-		return -1, fmt.Errorf("failed to acquire connection: %s", err.Error())
+
+	// order becomes PROCESSED only once, so accrual is credited only once
+	if status == "PROCESSED" && accrual != nil {
+		sql = `UPDATE orders SET accrual = $2 WHERE id = $1;`
+		_, err = tx.Exec(d.Ctx, sql, id, *accrual)
+		if err != nil {
+			return -1, fmt.Errorf("failed exec sql: %s", err.Error())
+		}
+		_, err = lockBalance(d.Ctx, tx, userid)
+		if err != nil {
+			return -1, err
+		}
+		entry := structs.LedgerEntry{Type: structs.LedgerAccrual, Amount: *accrual, Reference: fmt.Sprint(id)}
+		_, err = appendLedger(d.Ctx, tx, userid, entry, 0)
+		if err != nil {
+			return -1, err
+		}
 	}
-	defer conn.Release()
-	sql := `UPDATE orders SET accrual = $2 WHERE id = $1;`
-	res, err := conn.Exec(d.Ctx, sql, id, accrual)
+
+	err = tx.Commit(d.Ctx)
 	if err != nil {
-		return -1, fmt.Errorf("failed exec sql: %s", err.Error())
+		return -1, fmt.Errorf("failed to commit transaction: %s", err.Error())
 	}
 	count := res.RowsAffected()
 	return count, nil
@@ -287,33 +372,20 @@ func (d *DBConnector) GetUserBalance(id int) (structs.Balance, error) {
 	}
 	defer conn.Release()
 
-	// geting user accrual
-	sql := `SELECT COALESCE(SUM(accrual),0) AS acc_total
-			FROM orders
-			WHERE userid = $1;`
-	var accTotal float64
-	row := conn.QueryRow(d.Ctx, sql, id)
-	err = row.Scan(&accTotal)
-	if err != nil {
-		return structs.Balance{}, fmt.Errorf("failed to query orders table: %s", err.Error())
+	var balance structs.Balance
+	sql := `SELECT current, withdrawn FROM balances WHERE userid = $1;`
+	err = conn.QueryRow(d.Ctx, sql, id).Scan(&balance.Current, &balance.Withdrawn)
+	if err == pgx.ErrNoRows {
+		return structs.Balance{}, nil
 	}
-
-	// geting user withdrawals
-	sql = `SELECT COALESCE(SUM(amount),0) AS withdrawals_total
-		   FROM withdrawals
-		   WHERE userid = $1`
-	var wdTotal float64
-	row = conn.QueryRow(d.Ctx, sql, id)
-	err = row.Scan(&wdTotal)
 	if err != nil {
-		return structs.Balance{}, fmt.Errorf("failed to query withdrawals table: %s", err.Error())
+		return structs.Balance{}, fmt.Errorf("failed to query balances table: %s", err.Error())
 	}
-	// round to 5 digits
-	balance := structs.Balance{Current: accTotal - wdTotal, Withdrawn: wdTotal}
 	return balance, nil
 }
 
-func (d *DBConnector) Withdraw(userid int, winthdraw structs.Withdraw) error {
+func (d *DBConnector) Withdraw(userid int, winthdraw structs.Withdraw,
+	idempotency *structs.IdempotencyRecord) error {
 	err := d.checkInit()
 	if err != nil {
 		return err
@@ -325,101 +397,100 @@ func (d *DBConnector) Withdraw(userid int, winthdraw structs.Withdraw) error {
 	}
 	defer conn.Release()
 
-	sql := `INSERT INTO withdrawals (userid, orderid, amount, processed_at)
-		   VALUES($1, $2, $3, $4);`
-	_, err = conn.Exec(d.Ctx, sql, userid, winthdraw.Order, winthdraw.Sum, time.Now().Unix())
+	tx, err := conn.Begin(d.Ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %s", err.Error())
+	}
+	defer tx.Rollback(d.Ctx)
+
+	// balance row stays locked until this transaction ends,
+	// so concurrent withdrawals of the same user are serialized
+	balance, err := lockBalance(d.Ctx, tx, userid)
+	if err != nil {
+		return err
+	}
+	if balance.Current < winthdraw.Sum {
+		return fmt.Errorf("%w: winthdraw amount exceeds current balance (%s)",
+			structs.ErrInsufficientFunds, balance.Current)
+	}
+
+	entry := structs.LedgerEntry{Type: structs.LedgerWithdrawal, Amount: -winthdraw.Sum,
+		Reference: winthdraw.Order}
+	_, err = appendLedger(d.Ctx, tx, userid, entry, winthdraw.Sum)
+	if err != nil {
+		return err
+	}
+
+	// response is stored with withdrawal, so a retry never withdraws twice
+	if idempotency != nil {
+		err = saveIdempotencyResponse(d.Ctx, tx, userid, *idempotency)
+		if err != nil {
+			return err
+		}
+	}
 
-	return err
+	err = tx.Commit(d.Ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %s", err.Error())
+	}
+	return nil
 }
 
-func (d *DBConnector) GetWithdrawls(userid int) ([]structs.Withdraw, error) {
+func (d *DBConnector) GetWithdrawls(userid int, filter structs.ListFilter) ([]structs.Withdraw, *structs.Cursor, error) {
 	err := d.checkInit()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	conn, err := d.Pool.Acquire(d.Ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to acquire connection: %s", err.Error())
+		return nil, nil, fmt.Errorf("failed to acquire connection: %s", err.Error())
 	}
 	defer conn.Release()
 
-	sql := `SELECT amount, orderid, processed_at
-			FROM withdrawals
-			WHERE userid=$1`
-	rows, err := conn.Query(d.Ctx, sql, userid)
+	// reversed withdrawals are not shown
+	sql := `SELECT l.id, -l.amount, l.reference, l.created_ts
+			FROM ledger l
+			WHERE l.userid = $1 AND l.type = $2
+				AND NOT EXISTS (SELECT 1 FROM ledger r
+								WHERE r.type = $3 AND r.reference = l.id::text)`
+	args := []interface{}{userid, structs.LedgerWithdrawal, structs.LedgerReversal}
+	sql, args = applyListFilter(sql, args, "l.created_ts", "l.id", filter)
+	rows, err := conn.Query(d.Ctx, sql, args...)
 	if err != nil {
-		e := fmt.Errorf("failed to query withdrawals table: %s", err.Error())
-		return nil, e
+		e := fmt.Errorf("failed to query ledger table: %s", err.Error())
+		return nil, nil, e
 	}
 	defer rows.Close()
 
 	var withdrawals []structs.Withdraw
+	var cursors []structs.Cursor
 	for rows.Next() {
-		var orderid int
-		var amount float64
+		var id int64
+		var orderid string
+		var amount money.Amount
 		var processedAt int64
 
-		if err := rows.Scan(&amount, &orderid, &processedAt); err != nil {
-			e := fmt.Errorf("failed to scan row from withdrawals table: %s", err.Error())
-			return nil, e
+		if err := rows.Scan(&id, &amount, &orderid, &processedAt); err != nil {
+			e := fmt.Errorf("failed to scan row from ledger table: %s", err.Error())
+			return nil, nil, e
 		}
 		withdraw := structs.Withdraw{
-			Order:       fmt.Sprint(orderid),
+			Order:       orderid,
 			Sum:         amount,
 			ProcessedAt: time.Unix(processedAt, 0).Format("2006-01-02T15:04:05-07:00")}
 		withdrawals = append(withdrawals, withdraw)
+		cursors = append(cursors, structs.Cursor{TS: processedAt, ID: id})
 	}
 
 	if err := rows.Err(); err != nil {
-		e := fmt.Errorf("error(s) occured during withdrawals table scanning: %s", err.Error())
-		return nil, e
-	}
-
-	return withdrawals, nil
-
-}
-
-func (d *DBConnector) CreateTables() error {
-	conn, err := d.Pool.Acquire(d.Ctx)
-	defer conn.Release()
-	if err != nil {
-		return fmt.Errorf("failed to acquire connection: %s", err.Error())
-	}
-
-	usersSQL := `CREATE TABLE IF NOT EXISTS users (
-		id serial PRIMARY KEY,
-		login VARCHAR ( 50 ) UNIQUE NOT NULL,
-		password TEXT NOT NULL);`
-
-	_, err = conn.Exec(d.Ctx, usersSQL)
-	if err != nil {
-		return fmt.Errorf("cant create users table: %s", err.Error())
-	}
-
-	ordersSQL := `CREATE TABLE IF NOT EXISTS orders (
-		id bigint PRIMARY KEY,
-		status VARCHAR (15) DEFAULT 'NEW',
-		accrual real,
-		created_ts bigint NOT NULL,
-		userid integer REFERENCES users (id));`
-
-	_, err = conn.Exec(d.Ctx, ordersSQL)
-	if err != nil {
-		return fmt.Errorf("cant create orders table: %s", err.Error())
+		e := fmt.Errorf("error(s) occured during ledger table scanning: %s", err.Error())
+		return nil, nil, e
 	}
 
-	withdrawalsSQL := `CREATE TABLE IF NOT EXISTS withdrawals (
-		id serial PRIMARY KEY,
-		amount real NOT NULL,
-		processed_at bigint NOT NULL,
-		orderid bigint NOT NULL,
-		userid integer REFERENCES users (id));`
-
-	_, err = conn.Exec(d.Ctx, withdrawalsSQL)
-	if err != nil {
-		return fmt.Errorf("cant create orders withdrawals: %s", err.Error())
+	if filter.Limit > 0 && len(withdrawals) > filter.Limit {
+		return withdrawals[:filter.Limit], &cursors[filter.Limit-1], nil
 	}
+	return withdrawals, nil, nil
 
-	return nil
 }
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+func insertOrderEvent(ctx context.Context, tx pgx.Tx, orderid int, event structs.OrderEvent) error {
+	var from *string
+	if event.From != "" {
+		from = &event.From
+	}
+	var payload []byte
+	if len(event.Payload) != 0 {
+		payload = event.Payload
+	}
+	sql := `INSERT INTO order_events (orderid, status_from, status_to, source, payload, created_ts)
+			VALUES($1, $2, $3, $4, $5, $6);`
+	_, err := tx.Exec(ctx, sql, orderid, from, event.To, event.Source, payload, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("failed to insert order event: %s", err.Error())
+	}
+	return nil
+}
+
+// GetOrderHistory returns status transitions of the user`s order.
+// Returns structs.ErrOrderNotFound if order does not exist or belongs to another user
+func (d *DBConnector) GetOrderHistory(userid int, orderid int) ([]structs.OrderEvent, error) {
+	err := d.checkInit()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := d.Pool.Acquire(d.Ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %s", err.Error())
+	}
+	defer conn.Release()
+
+	var owner int
+	sql := `SELECT userid FROM orders WHERE id = $1;`
+	err = conn.QueryRow(d.Ctx, sql, orderid).Scan(&owner)
+	if err == pgx.ErrNoRows || (err == nil && owner != userid) {
+		return nil, structs.ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query orders table: %s", err.Error())
+	}
+
+	sql = `SELECT status_from, status_to, source, payload, created_ts
+		   FROM order_events
+		   WHERE orderid = $1
+		   ORDER BY id;`
+	rows, err := conn.Query(d.Ctx, sql, orderid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query order_events table: %s", err.Error())
+	}
+	defer rows.Close()
+
+	var events []structs.OrderEvent
+	for rows.Next() {
+		var e structs.OrderEvent
+		var from *string
+		var payload []byte
+		var createdTS int64
+		if err := rows.Scan(&from, &e.To, &e.Source, &payload, &createdTS); err != nil {
+			return nil, fmt.Errorf("failed to scan row from order_events table: %s", err.Error())
+		}
+		if from != nil {
+			e.From = *from
+		}
+		e.Payload = payload
+		e.CreatedAt = time.Unix(createdTS, 0).Format("2006-01-02T15:04:05-07:00")
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error(s) occured during order_events table scanning: %s", err.Error())
+	}
+	return events, nil
+}
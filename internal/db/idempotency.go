@@ -0,0 +1,138 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+// ReserveIdempotencyKey stores key of the request which is going to be processed.
+// If key was already used, stored record is returned and reserved is false.
+// Abandoned reservations and expired records are replaced.
+// CreatedAt of reservation identifies it in SaveIdempotencyResponse and DeleteIdempotencyKey
+func (d *DBConnector) ReserveIdempotencyKey(userid int, key string,
+	requestHash string) (structs.IdempotencyRecord, bool, error) {
+	err := d.checkInit()
+	if err != nil {
+		return structs.IdempotencyRecord{}, false, err
+	}
+
+	conn, err := d.Pool.Acquire(d.Ctx)
+	if err != nil {
+		return structs.IdempotencyRecord{}, false, fmt.Errorf("failed to acquire connection: %s", err.Error())
+	}
+	defer conn.Release()
+
+	now := time.Now()
+	sql := `DELETE FROM idempotency_keys WHERE userid = $1 AND created_ts < $2;`
+	_, err = conn.Exec(d.Ctx, sql, userid, now.Add(-structs.IdempotencyKeyTTL).Unix())
+	if err != nil {
+		return structs.IdempotencyRecord{}, false, fmt.Errorf("failed to delete expired idempotency keys: %s", err.Error())
+	}
+
+	// reservation of request which has not finished within lock timeout is taken over
+	sql = `INSERT INTO idempotency_keys (userid, key, request_hash, created_ts)
+		   VALUES($1, $2, $3, $4)
+		   ON CONFLICT (userid, key) DO UPDATE
+		   SET request_hash = EXCLUDED.request_hash, created_ts = EXCLUDED.created_ts,
+			   status_code = NULL, response = NULL
+		   WHERE idempotency_keys.status_code IS NULL AND idempotency_keys.created_ts < $5;`
+	res, err := conn.Exec(d.Ctx, sql, userid, key, requestHash, now.Unix(),
+		now.Add(-structs.IdempotencyLockTimeout).Unix())
+	if err != nil {
+		return structs.IdempotencyRecord{}, false, fmt.Errorf("failed to insert idempotency key: %s", err.Error())
+	}
+	if res.RowsAffected() == 1 {
+		return structs.IdempotencyRecord{Key: key, RequestHash: requestHash,
+			CreatedAt: time.Unix(now.Unix(), 0)}, true, nil
+	}
+
+	rec := structs.IdempotencyRecord{Key: key}
+	var code *int
+	var response *string
+	var createdTS int64
+	sql = `SELECT request_hash, status_code, response, created_ts
+		   FROM idempotency_keys
+		   WHERE userid = $1 AND key = $2;`
+	err = conn.QueryRow(d.Ctx, sql, userid, key).Scan(&rec.RequestHash, &code, &response, &createdTS)
+	if err != nil {
+		return structs.IdempotencyRecord{}, false, fmt.Errorf("failed to query idempotency_keys table: %s", err.Error())
+	}
+	if code != nil {
+		rec.StatusCode = *code
+	}
+	if response != nil {
+		rec.Response = []byte(*response)
+	}
+	rec.CreatedAt = time.Unix(createdTS, 0)
+	return rec, false, nil
+}
+
+// SaveIdempotencyResponse stores response of the request with reserved key
+func (d *DBConnector) SaveIdempotencyResponse(userid int, reservation structs.IdempotencyRecord) error {
+	err := d.checkInit()
+	if err != nil {
+		return err
+	}
+
+	conn, err := d.Pool.Acquire(d.Ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %s", err.Error())
+	}
+	defer conn.Release()
+
+	sql := `UPDATE idempotency_keys SET status_code = $3, response = $4
+			WHERE userid = $1 AND key = $2 AND created_ts = $5;`
+	res, err := conn.Exec(d.Ctx, sql, userid, reservation.Key, reservation.StatusCode,
+		string(reservation.Response), reservation.CreatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed exec sql: %s", err.Error())
+	}
+	if res.RowsAffected() != 1 {
+		return structs.ErrIdempotencyKeyLost
+	}
+	return nil
+}
+
+// saveIdempotencyResponse stores response of the request within transaction of the request.
+// Returns structs.ErrIdempotencyKeyLost if reservation was taken over, so transaction must be rolled back
+func saveIdempotencyResponse(ctx context.Context, tx pgx.Tx, userid int, rec structs.IdempotencyRecord) error {
+	sql := `UPDATE idempotency_keys SET status_code = $3, response = $4
+			WHERE userid = $1 AND key = $2 AND created_ts = $5;`
+	res, err := tx.Exec(ctx, sql, userid, rec.Key, rec.StatusCode, string(rec.Response), rec.CreatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to store idempotency response: %s", err.Error())
+	}
+	if res.RowsAffected() != 1 {
+		return structs.ErrIdempotencyKeyLost
+	}
+	return nil
+}
+
+// DeleteIdempotencyKey releases reserved key, so the request can be retried
+func (d *DBConnector) DeleteIdempotencyKey(userid int, reservation structs.IdempotencyRecord) error {
+	err := d.checkInit()
+	if err != nil {
+		return err
+	}
+
+	conn, err := d.Pool.Acquire(d.Ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %s", err.Error())
+	}
+	defer conn.Release()
+
+	sql := `DELETE FROM idempotency_keys WHERE userid = $1 AND key = $2 AND created_ts = $3;`
+	res, err := conn.Exec(d.Ctx, sql, userid, reservation.Key, reservation.CreatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed exec sql: %s", err.Error())
+	}
+	if res.RowsAffected() != 1 {
+		return structs.ErrIdempotencyKeyLost
+	}
+	return nil
+}
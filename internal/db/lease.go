@@ -0,0 +1,100 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// ClaimOrders leases up to limit orders which are due for processing to owner until lease expires.
+// Orders which status was changed less than minAge ago are skipped.
+// Rows locked by concurrent claims are skipped, so each order is claimed by one owner only
+func (d *DBConnector) ClaimOrders(owner string, limit int, lease time.Duration, minAge time.Duration) ([]int, error) {
+	err := d.checkInit()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := d.Pool.Acquire(d.Ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %s", err.Error())
+	}
+	defer conn.Release()
+
+	now := time.Now()
+	sql := `UPDATE orders
+			SET lease_owner = $1, lease_until = $2
+			WHERE id IN (
+				SELECT id
+				FROM orders
+				WHERE status IN ('NEW', 'PROCESSING') AND dead_ts IS NULL AND next_attempt_ts <= $3
+					AND (lease_until IS NULL OR lease_until < $3) AND updated_ts <= $5
+				ORDER BY next_attempt_ts, id
+				LIMIT $4
+				FOR UPDATE SKIP LOCKED)
+			RETURNING id;`
+	rows, err := conn.Query(d.Ctx, sql, owner, now.Add(lease).Unix(), now.Unix(), limit, now.Add(-minAge).Unix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim orders: %s", err.Error())
+	}
+	defer rows.Close()
+
+	var orders []int
+	for rows.Next() {
+		var orderNumber int
+		if err := rows.Scan(&orderNumber); err != nil {
+			return nil, fmt.Errorf("failed to scan row from orders table: %s", err.Error())
+		}
+		orders = append(orders, orderNumber)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error(s) occured during orders table scanning: %s", err.Error())
+	}
+	return orders, nil
+}
+
+// ClaimOrder leases order to owner if it is due for processing and is not leased by another owner
+func (d *DBConnector) ClaimOrder(owner string, id int, lease time.Duration) (bool, error) {
+	err := d.checkInit()
+	if err != nil {
+		return false, err
+	}
+
+	conn, err := d.Pool.Acquire(d.Ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire connection: %s", err.Error())
+	}
+	defer conn.Release()
+
+	now := time.Now()
+	sql := `UPDATE orders
+			SET lease_owner = $1, lease_until = $2
+			WHERE id = $3 AND status IN ('NEW', 'PROCESSING') AND dead_ts IS NULL
+				AND next_attempt_ts <= $4 AND (lease_until IS NULL OR lease_until < $4);`
+	res, err := conn.Exec(d.Ctx, sql, owner, now.Add(lease).Unix(), id, now.Unix())
+	if err != nil {
+		return false, fmt.Errorf("failed to claim order: %s", err.Error())
+	}
+	return res.RowsAffected() == 1, nil
+}
+
+// ReleaseOrders releases all leases of owner
+func (d *DBConnector) ReleaseOrders(owner string) (int64, error) {
+	err := d.checkInit()
+	if err != nil {
+		return -1, err
+	}
+
+	conn, err := d.Pool.Acquire(d.Ctx)
+	if err != nil {
+		return -1, fmt.Errorf("failed to acquire connection: %s", err.Error())
+	}
+	defer conn.Release()
+
+	sql := `UPDATE orders SET lease_owner = NULL, lease_until = NULL WHERE lease_owner = $1;`
+	res, err := conn.Exec(d.Ctx, sql, owner)
+	if err != nil {
+		return -1, fmt.Errorf("failed exec sql: %s", err.Error())
+	}
+	return res.RowsAffected(), nil
+}
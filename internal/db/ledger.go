@@ -0,0 +1,175 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/zklevsha/go-musthave-diploma/internal/money"
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+// lockBalance locks user`s balance row until the end of transaction
+func lockBalance(ctx context.Context, tx pgx.Tx, userid int) (structs.Balance, error) {
+	sql := `INSERT INTO balances (userid) VALUES($1) ON CONFLICT (userid) DO NOTHING;`
+	_, err := tx.Exec(ctx, sql, userid)
+	if err != nil {
+		return structs.Balance{}, fmt.Errorf("failed to create balance row: %s", err.Error())
+	}
+
+	var balance structs.Balance
+	sql = `SELECT current, withdrawn FROM balances WHERE userid = $1 FOR UPDATE;`
+	err = tx.QueryRow(ctx, sql, userid).Scan(&balance.Current, &balance.Withdrawn)
+	if err != nil {
+		return structs.Balance{}, fmt.Errorf("failed to lock balance row: %s", err.Error())
+	}
+	return balance, nil
+}
+
+// appendLedger inserts ledger entry and updates user`s balance.
+// Balance row must be locked by the caller (see lockBalance)
+func appendLedger(ctx context.Context, tx pgx.Tx, userid int,
+	entry structs.LedgerEntry, withdrawn money.Amount) (int64, error) {
+	var id int64
+	sql := `INSERT INTO ledger (userid, type, amount, reference, created_ts)
+			VALUES($1, $2, $3, $4, $5)
+			RETURNING id;`
+	err := tx.QueryRow(ctx, sql, userid, entry.Type, entry.Amount, entry.Reference,
+		time.Now().Unix()).Scan(&id)
+	if err != nil {
+		return -1, fmt.Errorf("failed to insert ledger entry: %s", err.Error())
+	}
+
+	sql = `UPDATE balances
+		   SET current = current + $2, withdrawn = withdrawn + $3
+		   WHERE userid = $1;`
+	_, err = tx.Exec(ctx, sql, userid, entry.Amount, withdrawn)
+	if err != nil {
+		return -1, fmt.Errorf("failed to update balance: %s", err.Error())
+	}
+	return id, nil
+}
+
+// AddLedgerEntry adds manual ADJUSTMENT or REVERSAL of existing entry.
+// Reference of REVERSAL is id of reversed entry, its amount is calculated from reversed entry
+func (d *DBConnector) AddLedgerEntry(userid int, entry structs.LedgerEntry) (structs.LedgerEntry, error) {
+	err := d.checkInit()
+	if err != nil {
+		return structs.LedgerEntry{}, err
+	}
+
+	conn, err := d.Pool.Acquire(d.Ctx)
+	if err != nil {
+		return structs.LedgerEntry{}, fmt.Errorf("failed to acquire connection: %s", err.Error())
+	}
+	defer conn.Release()
+
+	tx, err := conn.Begin(d.Ctx)
+	if err != nil {
+		return structs.LedgerEntry{}, fmt.Errorf("failed to begin transaction: %s", err.Error())
+	}
+	defer tx.Rollback(d.Ctx)
+
+	balance, err := lockBalance(d.Ctx, tx, userid)
+	if err != nil {
+		return structs.LedgerEntry{}, err
+	}
+
+	var withdrawn money.Amount
+	switch entry.Type {
+	case structs.LedgerAdjustment:
+		if entry.Reference == "" {
+			return structs.LedgerEntry{}, fmt.Errorf("adjustment reason is not set")
+		}
+	case structs.LedgerReversal:
+		reversedID, err := strconv.ParseInt(entry.Reference, 10, 64)
+		if err != nil {
+			return structs.LedgerEntry{}, fmt.Errorf("bad reversed entry id %s: %s", entry.Reference, err.Error())
+		}
+		var reversedType string
+		var reversedAmount money.Amount
+		sql := `SELECT type, amount FROM ledger WHERE id = $1 AND userid = $2;`
+		err = tx.QueryRow(d.Ctx, sql, reversedID, userid).Scan(&reversedType, &reversedAmount)
+		if err == pgx.ErrNoRows {
+			return structs.LedgerEntry{}, structs.ErrLedgerEntryNotFound
+		}
+		if err != nil {
+			return structs.LedgerEntry{}, fmt.Errorf("failed to query ledger table: %s", err.Error())
+		}
+		if reversedType == structs.LedgerReversal {
+			return structs.LedgerEntry{}, fmt.Errorf("reversal entry can not be reversed")
+		}
+		var reversed int
+		sql = `SELECT count(id) FROM ledger WHERE type = $1 AND reference = $2;`
+		err = tx.QueryRow(d.Ctx, sql, structs.LedgerReversal, entry.Reference).Scan(&reversed)
+		if err != nil {
+			return structs.LedgerEntry{}, fmt.Errorf("failed to query ledger table: %s", err.Error())
+		}
+		if reversed != 0 {
+			return structs.LedgerEntry{}, structs.ErrLedgerEntryReversed
+		}
+		entry.Amount = -reversedAmount
+		if reversedType == structs.LedgerWithdrawal {
+			withdrawn = reversedAmount
+		}
+	default:
+		return structs.LedgerEntry{}, fmt.Errorf("ledger entry of type %s can not be added manually", entry.Type)
+	}
+
+	if balance.Current+entry.Amount < 0 {
+		return structs.LedgerEntry{}, fmt.Errorf("%w: entry makes balance negative (%s)",
+			structs.ErrInsufficientFunds, balance.Current+entry.Amount)
+	}
+
+	entry.ID, err = appendLedger(d.Ctx, tx, userid, entry, withdrawn)
+	if err != nil {
+		return structs.LedgerEntry{}, err
+	}
+
+	err = tx.Commit(d.Ctx)
+	if err != nil {
+		return structs.LedgerEntry{}, fmt.Errorf("failed to commit transaction: %s", err.Error())
+	}
+	return entry, nil
+}
+
+func (d *DBConnector) GetLedger(userid int) ([]structs.LedgerEntry, error) {
+	err := d.checkInit()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := d.Pool.Acquire(d.Ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %s", err.Error())
+	}
+	defer conn.Release()
+
+	sql := `SELECT id, type, amount, reference, created_ts
+			FROM ledger
+			WHERE userid = $1
+			ORDER BY id;`
+	rows, err := conn.Query(d.Ctx, sql, userid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ledger table: %s", err.Error())
+	}
+	defer rows.Close()
+
+	var entries []structs.LedgerEntry
+	for rows.Next() {
+		var e structs.LedgerEntry
+		var createdTS int64
+		if err := rows.Scan(&e.ID, &e.Type, &e.Amount, &e.Reference, &createdTS); err != nil {
+			return nil, fmt.Errorf("failed to scan row from ledger table: %s", err.Error())
+		}
+		e.CreatedAt = time.Unix(createdTS, 0).Format("2006-01-02T15:04:05-07:00")
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error(s) occured during ledger table scanning: %s", err.Error())
+	}
+	return entries, nil
+}
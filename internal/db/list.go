@@ -0,0 +1,39 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+// applyListFilter appends date range, cursor, sorting and limit to the query.
+// Query must end with WHERE clause. tsCol and idCol are used for sorting and cursor.
+// One extra row is requested to find out if there is a next page
+func applyListFilter(sql string, args []interface{}, tsCol, idCol string,
+	f structs.ListFilter) (string, []interface{}) {
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.From != 0 {
+		sql += fmt.Sprintf(" AND %s >= %s", tsCol, arg(f.From))
+	}
+	if f.To != 0 {
+		sql += fmt.Sprintf(" AND %s < %s", tsCol, arg(f.To))
+	}
+
+	cmp, order := ">", "ASC"
+	if f.Desc {
+		cmp, order = "<", "DESC"
+	}
+	if f.After != nil {
+		sql += fmt.Sprintf(" AND (%s, %s) %s (%s, %s)",
+			tsCol, idCol, cmp, arg(f.After.TS), arg(f.After.ID))
+	}
+	sql += fmt.Sprintf(" ORDER BY %s %s, %s %s", tsCol, order, idCol, order)
+	if f.Limit > 0 {
+		sql += " LIMIT " + arg(f.Limit+1)
+	}
+	return sql, args
+}
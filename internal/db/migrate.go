@@ -0,0 +1,225 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// migrationsLockID is a key of postgres advisory lock.
+// It prevents several gophermart instances from migrating the same database simultaneously
+const migrationsLockID = 4236189750
+
+var migrationNameRe = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// loadMigrations reads embedded migrations sorted by version
+func loadMigrations() ([]Migration, error) {
+	files, err := fs.ReadDir(migrationsFS, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations directory: %s", err.Error())
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, f := range files {
+		m := migrationNameRe.FindStringSubmatch(f.Name())
+		if m == nil {
+			return nil, fmt.Errorf("bad migration file name: %s", f.Name())
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad migration version %s: %s", m[1], err.Error())
+		}
+		body, err := migrationsFS.ReadFile("migrations/" + f.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %s", f.Name(), err.Error())
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has different names: %s, %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	var migrations []Migration
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down scripts", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// withMigrationLock runs f on a dedicated connection holding migrations advisory lock
+func withMigrationLock(ctx context.Context, pool *pgxpool.Pool, f func(conn *pgxpool.Conn) error) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %s", err.Error())
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, `SELECT pg_advisory_lock($1);`, migrationsLockID)
+	if err != nil {
+		return fmt.Errorf("failed to get migrations lock: %s", err.Error())
+	}
+	defer conn.Exec(ctx, `SELECT pg_advisory_unlock($1);`, migrationsLockID)
+
+	sql := `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now());`
+	_, err = conn.Exec(ctx, sql)
+	if err != nil {
+		return fmt.Errorf("cant create schema_migrations table: %s", err.Error())
+	}
+	return f(conn)
+}
+
+func getAppliedMigrations(ctx context.Context, conn *pgxpool.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.Query(ctx, `SELECT version, applied_at FROM schema_migrations;`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema_migrations table: %s", err.Error())
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row from schema_migrations table: %s", err.Error())
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error(s) occured during schema_migrations table scanning: %s", err.Error())
+	}
+	return applied, nil
+}
+
+// runMigration executes migration script and updates schema_migrations in a single transaction
+func runMigration(ctx context.Context, conn *pgxpool.Conn, m Migration, up bool) error {
+	return conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if up {
+			if _, err := tx.Exec(ctx, m.Up); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version, name) VALUES($1, $2);`,
+				m.Version, m.Name)
+			return err
+		}
+		if _, err := tx.Exec(ctx, m.Down); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version = $1;`, m.Version)
+		return err
+	})
+}
+
+// MigrateUp applies all pending migrations
+func (d *DBConnector) MigrateUp() error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	return withMigrationLock(d.Ctx, d.Pool, func(conn *pgxpool.Conn) error {
+		applied, err := getAppliedMigrations(d.Ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			if err := runMigration(d.Ctx, conn, m, true); err != nil {
+				return fmt.Errorf("failed to apply migration %d_%s: %s", m.Version, m.Name, err.Error())
+			}
+			log.Printf("INFO db migration %d_%s was applied", m.Version, m.Name)
+		}
+		return nil
+	})
+}
+
+// MigrateDown rolls back last steps applied migrations
+func (d *DBConnector) MigrateDown(steps int) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	return withMigrationLock(d.Ctx, d.Pool, func(conn *pgxpool.Conn) error {
+		applied, err := getAppliedMigrations(d.Ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if err := runMigration(d.Ctx, conn, m, false); err != nil {
+				return fmt.Errorf("failed to roll back migration %d_%s: %s", m.Version, m.Name, err.Error())
+			}
+			log.Printf("INFO db migration %d_%s was rolled back", m.Version, m.Name)
+			steps--
+		}
+		return nil
+	})
+}
+
+// MigrationStatus returns all known migrations and whether they were applied
+func (d *DBConnector) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var status []MigrationStatus
+	err = withMigrationLock(d.Ctx, d.Pool, func(conn *pgxpool.Conn) error {
+		applied, err := getAppliedMigrations(d.Ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			appliedAt, ok := applied[m.Version]
+			status = append(status, MigrationStatus{Migration: m, Applied: ok, AppliedAt: appliedAt})
+		}
+		return nil
+	})
+	return status, err
+}
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS users (
+	id serial PRIMARY KEY,
+	login VARCHAR ( 50 ) UNIQUE NOT NULL,
+	password TEXT NOT NULL);
+
+CREATE TABLE IF NOT EXISTS orders (
+	id bigint PRIMARY KEY,
+	status VARCHAR (15) DEFAULT 'NEW',
+	accrual real,
+	created_ts bigint NOT NULL,
+	userid integer REFERENCES users (id));
+
+CREATE TABLE IF NOT EXISTS withdrawals (
+	id serial PRIMARY KEY,
+	amount real NOT NULL,
+	processed_at bigint NOT NULL,
+	orderid bigint NOT NULL,
+	userid integer REFERENCES users (id));
@@ -0,0 +1,18 @@
+CREATE TABLE withdrawals (
+	id serial PRIMARY KEY,
+	amount real NOT NULL,
+	processed_at bigint NOT NULL,
+	orderid bigint NOT NULL,
+	userid integer REFERENCES users (id));
+
+-- reversed withdrawals and adjustments can not be represented without ledger
+INSERT INTO withdrawals (amount, processed_at, orderid, userid)
+SELECT -l.amount, l.created_ts, l.reference::bigint, l.userid
+FROM ledger l
+WHERE l.type = 'WITHDRAWAL'
+	AND NOT EXISTS (SELECT 1 FROM ledger r WHERE r.type = 'REVERSAL' AND r.reference = l.id::text)
+ORDER BY l.id;
+
+DROP TABLE balances;
+DROP TABLE ledger;
+DROP FUNCTION ledger_immutable();
@@ -0,0 +1,48 @@
+-- every change of user balance is an immutable ledger entry.
+-- amount is signed: credits are positive, debits are negative
+CREATE TABLE ledger (
+	id bigserial PRIMARY KEY,
+	userid integer NOT NULL REFERENCES users (id),
+	type VARCHAR (15) NOT NULL,
+	amount real NOT NULL,
+	reference TEXT NOT NULL,
+	created_ts bigint NOT NULL);
+
+CREATE INDEX ledger_userid_type_idx ON ledger (userid, type);
+CREATE INDEX ledger_reversal_idx ON ledger (reference) WHERE type = 'REVERSAL';
+
+CREATE FUNCTION ledger_immutable() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'ledger entries are immutable';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER ledger_immutable BEFORE UPDATE OR DELETE ON ledger
+	FOR EACH ROW EXECUTE FUNCTION ledger_immutable();
+
+-- balances are maintained together with ledger entries
+CREATE TABLE balances (
+	userid integer PRIMARY KEY REFERENCES users (id),
+	current real NOT NULL DEFAULT 0,
+	withdrawn real NOT NULL DEFAULT 0);
+
+INSERT INTO ledger (userid, type, amount, reference, created_ts)
+SELECT userid, 'ACCRUAL', accrual, id::text, created_ts
+FROM orders
+WHERE accrual IS NOT NULL AND userid IS NOT NULL
+ORDER BY created_ts, id;
+
+INSERT INTO ledger (userid, type, amount, reference, created_ts)
+SELECT userid, 'WITHDRAWAL', -amount, orderid::text, processed_at
+FROM withdrawals
+WHERE userid IS NOT NULL
+ORDER BY id;
+
+INSERT INTO balances (userid, current, withdrawn)
+SELECT u.id,
+	   COALESCE(SUM(l.amount), 0),
+	   COALESCE(-SUM(l.amount) FILTER (WHERE l.type = 'WITHDRAWAL'), 0)
+FROM users u LEFT JOIN ledger l ON l.userid = u.id
+GROUP BY u.id;
+
+DROP TABLE withdrawals;
@@ -0,0 +1,10 @@
+ALTER TABLE balances
+	ALTER COLUMN current TYPE real USING current / 100.0,
+	ALTER COLUMN withdrawn TYPE real USING withdrawn / 100.0;
+ALTER TABLE ledger ALTER COLUMN amount TYPE real USING amount / 100.0;
+ALTER TABLE orders ALTER COLUMN accrual TYPE real USING accrual / 100.0;
+
+COMMENT ON COLUMN orders.accrual IS NULL;
+COMMENT ON COLUMN ledger.amount IS NULL;
+COMMENT ON COLUMN balances.current IS NULL;
+COMMENT ON COLUMN balances.withdrawn IS NULL;
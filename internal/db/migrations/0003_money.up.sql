@@ -0,0 +1,21 @@
+-- money is stored as bigint number of minor units (1/100 of a point)
+ALTER TABLE orders ALTER COLUMN accrual TYPE bigint USING round(accrual::numeric * 100);
+ALTER TABLE ledger ALTER COLUMN amount TYPE bigint USING round(amount::numeric * 100);
+ALTER TABLE balances
+	ALTER COLUMN current TYPE bigint USING round(current::numeric * 100),
+	ALTER COLUMN withdrawn TYPE bigint USING round(withdrawn::numeric * 100);
+
+COMMENT ON COLUMN orders.accrual IS 'minor units (1/100 of a point)';
+COMMENT ON COLUMN ledger.amount IS 'minor units (1/100 of a point)';
+COMMENT ON COLUMN balances.current IS 'minor units (1/100 of a point)';
+COMMENT ON COLUMN balances.withdrawn IS 'minor units (1/100 of a point)';
+
+-- balances accumulated float errors, so they are recalculated from ledger
+UPDATE balances b SET
+	current = COALESCE((SELECT SUM(l.amount) FROM ledger l WHERE l.userid = b.userid), 0),
+	withdrawn = COALESCE((SELECT -SUM(l.amount) FROM ledger l
+						  WHERE l.userid = b.userid
+							AND (l.type = 'WITHDRAWAL'
+								 OR (l.type = 'REVERSAL' AND EXISTS (
+										SELECT 1 FROM ledger w
+										WHERE w.type = 'WITHDRAWAL' AND w.id::text = l.reference)))), 0);
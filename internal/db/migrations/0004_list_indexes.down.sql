@@ -0,0 +1,3 @@
+CREATE INDEX ledger_userid_type_idx ON ledger (userid, type);
+DROP INDEX ledger_userid_type_created_idx;
+DROP INDEX orders_userid_created_idx;
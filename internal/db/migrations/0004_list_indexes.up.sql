@@ -0,0 +1,3 @@
+CREATE INDEX orders_userid_created_idx ON orders (userid, created_ts, id);
+CREATE INDEX ledger_userid_type_created_idx ON ledger (userid, type, created_ts, id);
+DROP INDEX ledger_userid_type_idx;
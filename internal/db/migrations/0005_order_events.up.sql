@@ -0,0 +1,19 @@
+CREATE TABLE order_events (
+	id bigserial PRIMARY KEY,
+	orderid bigint NOT NULL REFERENCES orders (id),
+	status_from VARCHAR (15),
+	status_to VARCHAR (15) NOT NULL,
+	source VARCHAR (15) NOT NULL,
+	payload jsonb,
+	created_ts bigint NOT NULL);
+
+CREATE INDEX order_events_orderid_idx ON order_events (orderid, id);
+
+-- history of existing orders is unknown, only their upload and current status are recorded
+INSERT INTO order_events (orderid, status_from, status_to, source, created_ts)
+SELECT id, NULL, 'NEW', 'user', created_ts FROM orders;
+
+INSERT INTO order_events (orderid, status_from, status_to, source, created_ts)
+SELECT id, 'NEW', status, 'migration', extract(epoch FROM now())::bigint
+FROM orders
+WHERE status <> 'NEW';
@@ -0,0 +1,9 @@
+-- status_code is NULL while request is in progress
+CREATE TABLE idempotency_keys (
+	userid integer NOT NULL REFERENCES users (id),
+	key VARCHAR (255) NOT NULL,
+	request_hash VARCHAR (64) NOT NULL,
+	status_code integer,
+	response TEXT,
+	created_ts bigint NOT NULL,
+	PRIMARY KEY (userid, key));
@@ -0,0 +1,7 @@
+DROP INDEX orders_dead_idx;
+DROP INDEX orders_unprocessed_idx;
+ALTER TABLE orders
+	DROP COLUMN dead_ts,
+	DROP COLUMN last_error,
+	DROP COLUMN next_attempt_ts,
+	DROP COLUMN attempts;
@@ -0,0 +1,10 @@
+-- next_attempt_ts is a unix time of the next processing attempt,
+-- dead_ts is set when order is dead-lettered after too many failed attempts
+ALTER TABLE orders
+	ADD COLUMN attempts integer NOT NULL DEFAULT 0,
+	ADD COLUMN next_attempt_ts bigint NOT NULL DEFAULT 0,
+	ADD COLUMN last_error TEXT,
+	ADD COLUMN dead_ts bigint;
+CREATE INDEX orders_unprocessed_idx ON orders (next_attempt_ts)
+	WHERE status IN ('NEW', 'PROCESSING') AND dead_ts IS NULL;
+CREATE INDEX orders_dead_idx ON orders (dead_ts) WHERE dead_ts IS NOT NULL;
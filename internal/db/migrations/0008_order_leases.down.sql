@@ -0,0 +1,4 @@
+DROP INDEX orders_lease_owner_idx;
+ALTER TABLE orders
+	DROP COLUMN lease_until,
+	DROP COLUMN lease_owner;
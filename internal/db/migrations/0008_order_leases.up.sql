@@ -0,0 +1,6 @@
+-- lease_owner is an id of processor which claimed the order,
+-- lease_until is a unix time after which the order can be claimed by another processor
+ALTER TABLE orders
+	ADD COLUMN lease_owner VARCHAR (255),
+	ADD COLUMN lease_until bigint;
+CREATE INDEX orders_lease_owner_idx ON orders (lease_owner) WHERE lease_owner IS NOT NULL;
@@ -0,0 +1,6 @@
+-- updated_ts is a unix time of the last order status change
+ALTER TABLE orders ADD COLUMN updated_ts bigint;
+UPDATE orders o
+SET updated_ts = COALESCE(
+	(SELECT max(e.created_ts) FROM order_events e WHERE e.orderid = o.id), o.created_ts);
+ALTER TABLE orders ALTER COLUMN updated_ts SET NOT NULL;
@@ -0,0 +1,19 @@
+-- token_hash is sha256 of refresh token, used_ts is set when token is rotated.
+-- access_jti is an id of access token issued together with refresh token
+CREATE TABLE refresh_tokens (
+	token_hash VARCHAR (64) PRIMARY KEY,
+	userid integer NOT NULL REFERENCES users (id),
+	family VARCHAR (32) NOT NULL,
+	created_ts bigint NOT NULL,
+	expires_ts bigint NOT NULL,
+	used_ts bigint,
+	revoked_ts bigint,
+	access_jti VARCHAR (32) NOT NULL,
+	access_expires_ts bigint NOT NULL);
+CREATE INDEX refresh_tokens_family_idx ON refresh_tokens (family);
+CREATE INDEX refresh_tokens_access_jti_idx ON refresh_tokens (access_jti);
+
+-- revoked access tokens are kept until they expire
+CREATE TABLE revoked_tokens (
+	jti VARCHAR (32) PRIMARY KEY,
+	expires_ts bigint NOT NULL);
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+)
+
+// newOrdersChannel is a postgres notification channel of new orders (payload is order id)
+const newOrdersChannel = "new_orders"
+
+// notificationsBuffer is a number of notifications kept while subscriber is busy,
+// the rest is dropped
+const notificationsBuffer = 100
+
+// listenRetryDelay is a delay before reconnecting listener after connection failure
+const listenRetryDelay = time.Duration(1 * time.Second)
+
+// SubscribeOrders returns channel of new order ids (postgres LISTEN/NOTIFY).
+// Notifications may be lost (e.g. on reconnect), so subscriber must not rely on them only.
+// Channel is closed when ctx is done
+func (d *DBConnector) SubscribeOrders(ctx context.Context) (<-chan int, error) {
+	err := d.checkInit()
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan int, notificationsBuffer)
+	go func() {
+		defer close(ch)
+		for {
+			err := d.listen(ctx, ch)
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("ERROR db new orders listener failed: %s. Reconnecting in %s",
+				err.Error(), listenRetryDelay)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(listenRetryDelay):
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// listen forwards notifications to ch until error occurs
+func (d *DBConnector) listen(ctx context.Context, ch chan<- int) error {
+	conn, err := d.Pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, "LISTEN "+newOrdersChannel+";")
+	if err != nil {
+		return err
+	}
+	// connection is returned to the pool, so it must not keep listening
+	defer conn.Exec(context.Background(), "UNLISTEN "+newOrdersChannel+";")
+
+	for {
+		n, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		id, err := strconv.Atoi(n.Payload)
+		if err != nil {
+			log.Printf("WARN db bad new order notification payload %q", n.Payload)
+			continue
+		}
+		select {
+		case ch <- id:
+		default:
+		}
+	}
+}
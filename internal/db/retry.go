@@ -0,0 +1,170 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+// RetryOrder registers failed processing attempt and schedules the next one according to backoff.
+// Order is dead-lettered when backoff.MaxAttempts is reached
+func (d *DBConnector) RetryOrder(id int, lastErr string, backoff structs.Backoff) (int, bool, error) {
+	err := d.checkInit()
+	if err != nil {
+		return -1, false, err
+	}
+	conn, err := d.Pool.Acquire(d.Ctx)
+	if err != nil {
+		return -1, false, fmt.Errorf("failed to acquire connection: %s", err.Error())
+	}
+	defer conn.Release()
+
+	tx, err := conn.Begin(d.Ctx)
+	if err != nil {
+		return -1, false, fmt.Errorf("failed to begin transaction: %s", err.Error())
+	}
+	defer tx.Rollback(d.Ctx)
+
+	var attempts int
+	sql := `SELECT attempts FROM orders WHERE id = $1 FOR UPDATE;`
+	err = tx.QueryRow(d.Ctx, sql, id).Scan(&attempts)
+	if err == pgx.ErrNoRows {
+		return -1, false, structs.ErrOrderNotFound
+	}
+	if err != nil {
+		return -1, false, fmt.Errorf("failed to query orders table: %s", err.Error())
+	}
+
+	attempts++
+	now := time.Now()
+	var deadTS *int64
+	dead := backoff.Dead(attempts)
+	if dead {
+		ts := now.Unix()
+		deadTS = &ts
+	}
+	sql = `UPDATE orders
+		   SET attempts = $2, next_attempt_ts = $3, last_error = $4, dead_ts = $5
+		   WHERE id = $1;`
+	_, err = tx.Exec(d.Ctx, sql, id, attempts, now.Add(backoff.Delay(attempts)).Unix(), lastErr, deadTS)
+	if err != nil {
+		return -1, false, fmt.Errorf("failed exec sql: %s", err.Error())
+	}
+
+	err = tx.Commit(d.Ctx)
+	if err != nil {
+		return -1, false, fmt.Errorf("failed to commit transaction: %s", err.Error())
+	}
+	return attempts, dead, nil
+}
+
+// PostponeOrder schedules the next processing attempt after delay without counting failed attempt
+func (d *DBConnector) PostponeOrder(id int, reason string, delay time.Duration) error {
+	err := d.checkInit()
+	if err != nil {
+		return err
+	}
+	conn, err := d.Pool.Acquire(d.Ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %s", err.Error())
+	}
+	defer conn.Release()
+
+	sql := `UPDATE orders SET next_attempt_ts = $2, last_error = $3 WHERE id = $1;`
+	res, err := conn.Exec(d.Ctx, sql, id, time.Now().Add(delay).Unix(), reason)
+	if err != nil {
+		return fmt.Errorf("failed exec sql: %s", err.Error())
+	}
+	if res.RowsAffected() == 0 {
+		return structs.ErrOrderNotFound
+	}
+	return nil
+}
+
+// GetDeadOrders returns dead-lettered orders
+func (d *DBConnector) GetDeadOrders() ([]structs.DeadOrder, error) {
+	err := d.checkInit()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := d.Pool.Acquire(d.Ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %s", err.Error())
+	}
+	defer conn.Release()
+
+	sql := `SELECT id, status, attempts, COALESCE(last_error, ''), dead_ts
+			FROM orders
+			WHERE dead_ts IS NOT NULL
+			ORDER BY dead_ts, id;`
+	rows, err := conn.Query(d.Ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query orders table: %s", err.Error())
+	}
+	defer rows.Close()
+
+	var orders []structs.DeadOrder
+	for rows.Next() {
+		var o structs.DeadOrder
+		var orderNumber int
+		var deadTS int64
+		if err := rows.Scan(&orderNumber, &o.Status, &o.Attempts, &o.LastError, &deadTS); err != nil {
+			return nil, fmt.Errorf("failed to scan row from orders table: %s", err.Error())
+		}
+		o.Number = fmt.Sprint(orderNumber)
+		o.DeadAt = time.Unix(deadTS, 0).Format("2006-01-02T15:04:05-07:00")
+		orders = append(orders, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error(s) occured during orders table scanning: %s", err.Error())
+	}
+	return orders, nil
+}
+
+// RequeueOrder returns dead-lettered order to processing and records it in order history.
+// Returns 0 if order does not exist or is not dead-lettered
+func (d *DBConnector) RequeueOrder(id int) (int64, error) {
+	err := d.checkInit()
+	if err != nil {
+		return -1, err
+	}
+	conn, err := d.Pool.Acquire(d.Ctx)
+	if err != nil {
+		return -1, fmt.Errorf("failed to acquire connection: %s", err.Error())
+	}
+	defer conn.Release()
+
+	tx, err := conn.Begin(d.Ctx)
+	if err != nil {
+		return -1, fmt.Errorf("failed to begin transaction: %s", err.Error())
+	}
+	defer tx.Rollback(d.Ctx)
+
+	var status string
+	sql := `UPDATE orders
+		   SET attempts = 0, next_attempt_ts = 0, last_error = NULL, dead_ts = NULL
+		   WHERE id = $1 AND dead_ts IS NOT NULL
+		   RETURNING status;`
+	err = tx.QueryRow(d.Ctx, sql, id).Scan(&status)
+	if err == pgx.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return -1, fmt.Errorf("failed exec sql: %s", err.Error())
+	}
+
+	event := structs.OrderEvent{From: status, To: status, Source: structs.SourceAdmin,
+		Payload: structs.RequeuePayload}
+	err = insertOrderEvent(d.Ctx, tx, id, event)
+	if err != nil {
+		return -1, err
+	}
+
+	err = tx.Commit(d.Ctx)
+	if err != nil {
+		return -1, fmt.Errorf("failed to commit transaction: %s", err.Error())
+	}
+	return 1, nil
+}
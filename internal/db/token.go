@@ -0,0 +1,204 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+// CreateRefreshToken stores refresh token of a new family (login).
+// Expired tokens are removed at the same time
+func (d *DBConnector) CreateRefreshToken(t structs.RefreshToken) error {
+	err := d.checkInit()
+	if err != nil {
+		return err
+	}
+	conn, err := d.Pool.Acquire(d.Ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %s", err.Error())
+	}
+	defer conn.Release()
+
+	now := time.Now().Unix()
+	sql := `DELETE FROM refresh_tokens WHERE expires_ts < $1;`
+	_, err = conn.Exec(d.Ctx, sql, now)
+	if err != nil {
+		return fmt.Errorf("failed to delete expired refresh tokens: %s", err.Error())
+	}
+	sql = `INSERT INTO refresh_tokens
+		   (token_hash, userid, family, created_ts, expires_ts, access_jti, access_expires_ts)
+		   VALUES($1, $2, $3, $4, $5, $6, $7);`
+	_, err = conn.Exec(d.Ctx, sql, t.Hash, t.UserID, t.Family, now, t.ExpiresAt.Unix(),
+		t.AccessID, t.AccessExpiresAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to insert refresh token: %s", err.Error())
+	}
+	return nil
+}
+
+// RotateRefreshToken marks refresh token as used and stores next one in the same family.
+// If token was already used, whole family is revoked and structs.ErrRefreshTokenReused is returned
+func (d *DBConnector) RotateRefreshToken(hash string, next structs.RefreshToken) (int, error) {
+	err := d.checkInit()
+	if err != nil {
+		return -1, err
+	}
+	conn, err := d.Pool.Acquire(d.Ctx)
+	if err != nil {
+		return -1, fmt.Errorf("failed to acquire connection: %s", err.Error())
+	}
+	defer conn.Release()
+
+	tx, err := conn.Begin(d.Ctx)
+	if err != nil {
+		return -1, fmt.Errorf("failed to begin transaction: %s", err.Error())
+	}
+	defer tx.Rollback(d.Ctx)
+
+	var userid int
+	var family string
+	var expiresTS int64
+	var usedTS, revokedTS *int64
+	sql := `SELECT userid, family, expires_ts, used_ts, revoked_ts
+			FROM refresh_tokens
+			WHERE token_hash = $1 FOR UPDATE;`
+	err = tx.QueryRow(d.Ctx, sql, hash).Scan(&userid, &family, &expiresTS, &usedTS, &revokedTS)
+	if err == pgx.ErrNoRows {
+		return -1, structs.ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return -1, fmt.Errorf("failed to query refresh_tokens table: %s", err.Error())
+	}
+
+	now := time.Now().Unix()
+	if revokedTS != nil || expiresTS < now {
+		return -1, structs.ErrRefreshTokenInvalid
+	}
+	if usedTS != nil {
+		// token was stolen or leaked: neither thief nor user can continue the session
+		err = revokeFamily(d.Ctx, tx, family, now)
+		if err != nil {
+			return -1, err
+		}
+		err = tx.Commit(d.Ctx)
+		if err != nil {
+			return -1, fmt.Errorf("failed to commit transaction: %s", err.Error())
+		}
+		return -1, structs.ErrRefreshTokenReused
+	}
+
+	sql = `UPDATE refresh_tokens SET used_ts = $2 WHERE token_hash = $1;`
+	_, err = tx.Exec(d.Ctx, sql, hash, now)
+	if err != nil {
+		return -1, fmt.Errorf("failed to update refresh token: %s", err.Error())
+	}
+	sql = `INSERT INTO refresh_tokens
+		   (token_hash, userid, family, created_ts, expires_ts, access_jti, access_expires_ts)
+		   VALUES($1, $2, $3, $4, $5, $6, $7);`
+	_, err = tx.Exec(d.Ctx, sql, next.Hash, userid, family, now, next.ExpiresAt.Unix(),
+		next.AccessID, next.AccessExpiresAt.Unix())
+	if err != nil {
+		return -1, fmt.Errorf("failed to insert refresh token: %s", err.Error())
+	}
+
+	err = tx.Commit(d.Ctx)
+	if err != nil {
+		return -1, fmt.Errorf("failed to commit transaction: %s", err.Error())
+	}
+	return userid, nil
+}
+
+// RevokeSession revokes access token and refresh token family it was issued with
+func (d *DBConnector) RevokeSession(userid int, accessID string, accessExpiresAt time.Time) error {
+	err := d.checkInit()
+	if err != nil {
+		return err
+	}
+	conn, err := d.Pool.Acquire(d.Ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %s", err.Error())
+	}
+	defer conn.Release()
+
+	tx, err := conn.Begin(d.Ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %s", err.Error())
+	}
+	defer tx.Rollback(d.Ctx)
+
+	now := time.Now().Unix()
+	sql := `INSERT INTO revoked_tokens (jti, expires_ts)
+			VALUES($1, $2)
+			ON CONFLICT (jti) DO NOTHING;`
+	_, err = tx.Exec(d.Ctx, sql, accessID, accessExpiresAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to insert revoked token: %s", err.Error())
+	}
+
+	var family string
+	sql = `SELECT family FROM refresh_tokens WHERE access_jti = $1 AND userid = $2;`
+	err = tx.QueryRow(d.Ctx, sql, accessID, userid).Scan(&family)
+	if err != nil && err != pgx.ErrNoRows {
+		return fmt.Errorf("failed to query refresh_tokens table: %s", err.Error())
+	}
+	if err == nil {
+		err = revokeFamily(d.Ctx, tx, family, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit(d.Ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %s", err.Error())
+	}
+	return nil
+}
+
+func (d *DBConnector) IsTokenRevoked(accessID string) (bool, error) {
+	err := d.checkInit()
+	if err != nil {
+		return false, err
+	}
+	conn, err := d.Pool.Acquire(d.Ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire connection: %s", err.Error())
+	}
+	defer conn.Release()
+
+	var revoked bool
+	sql := `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1);`
+	err = conn.QueryRow(d.Ctx, sql, accessID).Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("failed to query revoked_tokens table: %s", err.Error())
+	}
+	return revoked, nil
+}
+
+// revokeFamily revokes all refresh tokens of the family and access tokens issued with them.
+// Expired revoked access tokens are removed at the same time
+func revokeFamily(ctx context.Context, tx pgx.Tx, family string, now int64) error {
+	sql := `UPDATE refresh_tokens SET revoked_ts = $2
+			WHERE family = $1 AND revoked_ts IS NULL;`
+	_, err := tx.Exec(ctx, sql, family, now)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %s", err.Error())
+	}
+	sql = `INSERT INTO revoked_tokens (jti, expires_ts)
+		   SELECT access_jti, access_expires_ts FROM refresh_tokens
+		   WHERE family = $1 AND access_expires_ts >= $2
+		   ON CONFLICT (jti) DO NOTHING;`
+	_, err = tx.Exec(ctx, sql, family, now)
+	if err != nil {
+		return fmt.Errorf("failed to revoke access tokens: %s", err.Error())
+	}
+	sql = `DELETE FROM revoked_tokens WHERE expires_ts < $1;`
+	_, err = tx.Exec(ctx, sql, now)
+	if err != nil {
+		return fmt.Errorf("failed to delete expired revoked tokens: %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+// Package emulator implements accrual system state according to the specification.
+//
+// Goods of registered order are matched against reward rules (the first registered
+// rule which match is a substring of goods description wins). Order accrual is a sum
+// of rewards of all goods. Order without matched goods is INVALID.
+// Each GET of the order moves it one step further: REGISTERED -> PROCESSING -> PROCESSED (INVALID).
+// Scripted scenarios (see Scenarios) take precedence over this logic
+package emulator
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/luhn"
+	"github.com/zklevsha/go-musthave-diploma/internal/money"
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+// Reward types
+const (
+	RewardPercent = "%"
+	RewardPoints  = "pt"
+)
+
+var ErrBadRequest = errors.New("bad request")
+var ErrOrderRegistered = errors.New("order is already registered")
+var ErrRewardRegistered = errors.New("reward for this match is already registered")
+
+type Good struct {
+	Description string       `json:"description"`
+	Price       money.Amount `json:"price"`
+}
+
+type Order struct {
+	Order string `json:"order"`
+	Goods []Good `json:"goods"`
+}
+
+type Reward struct {
+	Match      string       `json:"match"`
+	Reward     money.Amount `json:"reward"`
+	RewardType string       `json:"reward_type"`
+}
+
+type order struct {
+	goods   []Good
+	status  string
+	accrual money.Amount
+}
+
+type Emulator struct {
+	mu      sync.Mutex
+	rewards []Reward
+	orders  map[string]*order
+	// scripted responses and number of used steps per order
+	scenarios []Scenario
+	steps     map[string]int
+	faults    Faults
+	limiter   *rateLimiter
+}
+
+func New() *Emulator {
+	return &Emulator{
+		orders:  make(map[string]*order),
+		steps:   make(map[string]int),
+		limiter: newRateLimiter(),
+	}
+}
+
+// RegisterOrder registers order for accrual calculation
+func (e *Emulator) RegisterOrder(o Order) error {
+	id, err := strconv.Atoi(o.Order)
+	if err != nil || !luhn.Valid(id) {
+		return fmt.Errorf("%w: invalid order number %q", ErrBadRequest, o.Order)
+	}
+	for _, g := range o.Goods {
+		if g.Price < 0 {
+			return fmt.Errorf("%w: price of %q is negative", ErrBadRequest, g.Description)
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.orders[o.Order]; ok {
+		return ErrOrderRegistered
+	}
+	e.orders[o.Order] = &order{goods: o.Goods, status: "REGISTERED"}
+	return nil
+}
+
+// RegisterReward registers reward rule for goods which description contains r.Match
+func (e *Emulator) RegisterReward(r Reward) error {
+	if r.Match == "" {
+		return fmt.Errorf("%w: match is empty", ErrBadRequest)
+	}
+	if r.RewardType != RewardPercent && r.RewardType != RewardPoints {
+		return fmt.Errorf("%w: reward_type must be %q or %q", ErrBadRequest, RewardPercent, RewardPoints)
+	}
+	if r.Reward < 0 {
+		return fmt.Errorf("%w: reward is negative", ErrBadRequest)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, existing := range e.rewards {
+		if existing.Match == r.Match {
+			return ErrRewardRegistered
+		}
+	}
+	e.rewards = append(e.rewards, r)
+	return nil
+}
+
+// GetOrder returns current state of order and moves it to the next status.
+// Returns false if order is not registered
+func (e *Emulator) GetOrder(number string) (structs.Order, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	o, ok := e.orders[number]
+	if !ok {
+		return structs.Order{}, false
+	}
+
+	resp := structs.Order{Order: number, Status: o.status}
+	if o.status == "PROCESSED" {
+		accrual := o.accrual
+		resp.Accrual = &accrual
+	}
+
+	switch o.status {
+	case "REGISTERED":
+		o.status = "PROCESSING"
+	case "PROCESSING":
+		accrual, matched := e.calculate(o.goods)
+		if matched {
+			o.status = "PROCESSED"
+			o.accrual = accrual
+		} else {
+			o.status = "INVALID"
+		}
+	}
+	return resp, true
+}
+
+// calculate returns sum of rewards of goods and whether any good matched. e.mu must be locked
+func (e *Emulator) calculate(goods []Good) (money.Amount, bool) {
+	var accrual money.Amount
+	matched := false
+	for _, g := range goods {
+		for _, r := range e.rewards {
+			if !strings.Contains(g.Description, r.Match) {
+				continue
+			}
+			matched = true
+			if r.RewardType == RewardPercent {
+				accrual += g.Price.Percent(r.Reward)
+			} else {
+				accrual += r.Reward
+			}
+			break
+		}
+	}
+	return accrual, matched
+}
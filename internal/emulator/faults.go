@@ -0,0 +1,195 @@
+package emulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Faults configures fault injection. Probabilities are in percent (0-100)
+type Faults struct {
+	// Latency is a distribution of response delay:
+	// "" (none), "100ms" (fixed), "uniform:10ms,200ms", "normal:100ms,20ms" (mean, stddev)
+	// or "exp:100ms" (mean)
+	Latency string `json:"latency"`
+	// TooManyRequests is a probability of 429 response with RetryAfter window
+	TooManyRequests float64 `json:"too_many_requests"`
+	RetryAfter      string  `json:"retry_after"`
+	// ServerError is a probability of 500 response
+	ServerError float64 `json:"server_error"`
+	// ConnReset is a probability of connection reset without response
+	ConnReset float64 `json:"conn_reset"`
+	// Truncated and Malformed are probabilities of broken JSON in successful responses
+	Truncated float64 `json:"truncated"`
+	Malformed float64 `json:"malformed"`
+
+	latency    latency
+	retryAfter time.Duration
+}
+
+type latency struct {
+	kind string
+	a, b time.Duration
+}
+
+// Fault types returned by Faults.Roll
+const (
+	FaultNone            = ""
+	FaultConnReset       = "conn_reset"
+	FaultServerError     = "server_error"
+	FaultTooManyRequests = "too_many_requests"
+)
+
+// Body fault types returned by Faults.RollBody
+const (
+	FaultTruncated = "truncated"
+	FaultMalformed = "malformed"
+)
+
+// ParseFaults parses and validates faults
+func ParseFaults(b []byte) (Faults, error) {
+	var f Faults
+	err := json.Unmarshal(b, &f)
+	if err != nil {
+		return Faults{}, fmt.Errorf("%w: failed to unmarshal faults: %s", ErrBadRequest, err.Error())
+	}
+	err = f.Init()
+	if err != nil {
+		return Faults{}, err
+	}
+	return f, nil
+}
+
+// Init validates faults and parses latency and retry after window
+func (f *Faults) Init() error {
+	for name, p := range map[string]float64{"too_many_requests": f.TooManyRequests,
+		"server_error": f.ServerError, "conn_reset": f.ConnReset,
+		"truncated": f.Truncated, "malformed": f.Malformed} {
+		if p < 0 || p > 100 {
+			return fmt.Errorf("%w: probability %s must be in range [0, 100]", ErrBadRequest, name)
+		}
+	}
+	if f.ConnReset+f.ServerError+f.TooManyRequests > 100 {
+		return fmt.Errorf("%w: sum of conn_reset, server_error and too_many_requests exceeds 100", ErrBadRequest)
+	}
+	if f.Truncated+f.Malformed > 100 {
+		return fmt.Errorf("%w: sum of truncated and malformed exceeds 100", ErrBadRequest)
+	}
+
+	f.retryAfter = 0
+	if f.RetryAfter != "" {
+		d, err := time.ParseDuration(f.RetryAfter)
+		if err != nil || d < 0 {
+			return fmt.Errorf("%w: bad retry_after %q", ErrBadRequest, f.RetryAfter)
+		}
+		f.retryAfter = d
+	}
+
+	l, err := parseLatency(f.Latency)
+	if err != nil {
+		return fmt.Errorf("%w: bad latency %q: %s", ErrBadRequest, f.Latency, err.Error())
+	}
+	f.latency = l
+	return nil
+}
+
+func parseLatency(s string) (latency, error) {
+	if s == "" {
+		return latency{}, nil
+	}
+	kind, params := "fixed", s
+	if i := strings.Index(s, ":"); i >= 0 {
+		kind, params = s[:i], s[i+1:]
+	}
+	var values []time.Duration
+	for _, p := range strings.Split(params, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(p))
+		if err != nil {
+			return latency{}, err
+		}
+		if d < 0 {
+			return latency{}, fmt.Errorf("duration %s is negative", d)
+		}
+		values = append(values, d)
+	}
+	switch {
+	case (kind == "fixed" || kind == "exp") && len(values) == 1:
+		return latency{kind: kind, a: values[0]}, nil
+	case (kind == "uniform" || kind == "normal") && len(values) == 2:
+		if kind == "uniform" && values[1] < values[0] {
+			return latency{}, fmt.Errorf("max is less than min")
+		}
+		return latency{kind: kind, a: values[0], b: values[1]}, nil
+	default:
+		return latency{}, fmt.Errorf("unknown distribution or wrong number of parameters")
+	}
+}
+
+// Delay returns random response delay
+func (f Faults) Delay() time.Duration {
+	l := f.latency
+	var d time.Duration
+	switch l.kind {
+	case "fixed":
+		d = l.a
+	case "uniform":
+		d = l.a
+		if l.b > l.a {
+			d += time.Duration(rand.Int63n(int64(l.b - l.a)))
+		}
+	case "normal":
+		d = time.Duration(rand.NormFloat64()*float64(l.b) + float64(l.a))
+	case "exp":
+		d = time.Duration(rand.ExpFloat64() * float64(l.a))
+	}
+	return time.Duration(math.Max(0, float64(d)))
+}
+
+// GetRetryAfter returns window of 429 response
+func (f Faults) GetRetryAfter() time.Duration {
+	return f.retryAfter
+}
+
+// Roll returns fault which must replace the response (FaultNone if there is no fault)
+func (f Faults) Roll() string {
+	x := rand.Float64() * 100
+	switch {
+	case x < f.ConnReset:
+		return FaultConnReset
+	case x < f.ConnReset+f.ServerError:
+		return FaultServerError
+	case x < f.ConnReset+f.ServerError+f.TooManyRequests:
+		return FaultTooManyRequests
+	default:
+		return FaultNone
+	}
+}
+
+// RollBody returns fault of successful response body (FaultNone if there is no fault)
+func (f Faults) RollBody() string {
+	x := rand.Float64() * 100
+	switch {
+	case x < f.Truncated:
+		return FaultTruncated
+	case x < f.Truncated+f.Malformed:
+		return FaultMalformed
+	default:
+		return FaultNone
+	}
+}
+
+// SetFaults replaces faults
+func (e *Emulator) SetFaults(f Faults) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.faults = f
+}
+
+func (e *Emulator) GetFaults() Faults {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.faults
+}
@@ -0,0 +1,98 @@
+package emulator
+
+import (
+	"sync"
+	"time"
+)
+
+// bucket is a token bucket of a single client
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter limits number of requests per minute of each client.
+// Each client has a bucket of limit tokens refilled at rate of limit tokens per minute
+type rateLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*bucket)}
+}
+
+// SetLimit changes requests per minute limit (0 - no limit) and resets all buckets
+func (l *rateLimiter) SetLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+	l.buckets = make(map[string]*bucket)
+}
+
+// Limit returns requests per minute limit
+func (l *rateLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// Allow takes token from client`s bucket.
+// If bucket is empty it returns false and time until the next token is available
+func (l *rateLimiter) Allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limit <= 0 {
+		return true, 0
+	}
+	l.sweep(now)
+
+	perToken := time.Minute / time.Duration(l.limit)
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit), last: now}
+		l.buckets[client] = b
+	}
+	b.tokens += float64(now.Sub(b.last)) / float64(perToken)
+	if b.tokens > float64(l.limit) {
+		b.tokens = float64(l.limit)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) * float64(perToken))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep removes buckets which are full again, so idle clients do not hold memory
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+	for client, b := range l.buckets {
+		if now.Sub(b.last) >= time.Minute {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// SetRateLimit limits number of requests per minute of each client (0 - no limit)
+func (e *Emulator) SetRateLimit(limit int) {
+	e.limiter.SetLimit(limit)
+}
+
+// RateLimit returns number of requests per minute allowed to each client
+func (e *Emulator) RateLimit() int {
+	return e.limiter.Limit()
+}
+
+// AllowRequest reports whether client`s request is within rate limit.
+// If it is not, the time after which request can be retried is returned
+func (e *Emulator) AllowRequest(client string) (bool, time.Duration) {
+	return e.limiter.Allow(client, time.Now())
+}
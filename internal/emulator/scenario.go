@@ -0,0 +1,161 @@
+package emulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/money"
+)
+
+// Scenarios is a content of scenario file:
+//
+//	{"scenarios": [
+//		{"order": "12345678903", "responses": [
+//			{"status": "PROCESSING"},
+//			{"code": 429, "headers": {"Retry-After": "5"}},
+//			{"status": "PROCESSED", "accrual": 500, "delay": "200ms"}]},
+//		{"pattern": "^7", "responses": [{"status": "INVALID"}]}]}
+//
+// The first scenario which matches order number (order or pattern) is used.
+// Each GET of the order returns the next response, the last one is repeated
+type Scenarios struct {
+	Scenarios []Scenario `json:"scenarios"`
+}
+
+type Scenario struct {
+	// Order is an exact order number
+	Order string `json:"order,omitempty"`
+	// Pattern is a regular expression of order number (used if Order is empty)
+	Pattern   string `json:"pattern,omitempty"`
+	Responses []Step `json:"responses"`
+	re        *regexp.Regexp
+}
+
+// Step is a single scripted response
+type Step struct {
+	// Code is HTTP status code in range [200, 599] (200 by default)
+	Code int `json:"code,omitempty"`
+	// Status and Accrual are used in body of 200 response
+	Status  string        `json:"status,omitempty"`
+	Accrual *money.Amount `json:"accrual,omitempty"`
+	// Delay is a delay before response (time.Duration string)
+	Delay   string            `json:"delay,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Body replaces generated response body
+	Body  *string `json:"body,omitempty"`
+	delay time.Duration
+}
+
+// BodyAllowed reports whether response with the code may have a body (RFC 9110)
+func BodyAllowed(code int) bool {
+	return code >= 200 && code != http.StatusNoContent && code != http.StatusNotModified
+}
+
+// GetDelay returns parsed Delay
+func (s Step) GetDelay() time.Duration {
+	return s.delay
+}
+
+func (s *Scenario) match(number string) bool {
+	if s.Order != "" {
+		return s.Order == number
+	}
+	return s.re.MatchString(number)
+}
+
+// ParseScenarios parses and validates scenarios
+func ParseScenarios(b []byte) (Scenarios, error) {
+	var s Scenarios
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return Scenarios{}, fmt.Errorf("%w: failed to unmarshal scenarios: %s", ErrBadRequest, err.Error())
+	}
+	for i := range s.Scenarios {
+		sc := &s.Scenarios[i]
+		if sc.Order == "" && sc.Pattern == "" {
+			return Scenarios{}, fmt.Errorf("%w: scenario %d has neither order nor pattern", ErrBadRequest, i)
+		}
+		if sc.Order == "" {
+			sc.re, err = regexp.Compile(sc.Pattern)
+			if err != nil {
+				return Scenarios{}, fmt.Errorf("%w: bad pattern of scenario %d: %s", ErrBadRequest, i, err.Error())
+			}
+		}
+		if len(sc.Responses) == 0 {
+			return Scenarios{}, fmt.Errorf("%w: scenario %d has no responses", ErrBadRequest, i)
+		}
+		for j := range sc.Responses {
+			step := &sc.Responses[j]
+			if step.Code == 0 {
+				step.Code = 200
+			}
+			// informational responses are followed by implicit 200, so they can`t be scripted
+			if step.Code < 200 || step.Code > 599 {
+				return Scenarios{}, fmt.Errorf("%w: bad code %d in scenario %d", ErrBadRequest, step.Code, i)
+			}
+			if step.Body != nil && !BodyAllowed(step.Code) {
+				return Scenarios{}, fmt.Errorf("%w: response with code %d in scenario %d can`t have a body",
+					ErrBadRequest, step.Code, i)
+			}
+			if step.Delay != "" {
+				step.delay, err = time.ParseDuration(step.Delay)
+				if err != nil {
+					return Scenarios{}, fmt.Errorf("%w: bad delay in scenario %d: %s", ErrBadRequest, i, err.Error())
+				}
+			}
+		}
+	}
+	return s, nil
+}
+
+// LoadScenarioFile replaces scenarios with content of file
+func (e *Emulator) LoadScenarioFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read scenario file: %s", err.Error())
+	}
+	s, err := ParseScenarios(b)
+	if err != nil {
+		return err
+	}
+	e.SetScenarios(s)
+	return nil
+}
+
+// SetScenarios replaces scenarios and resets their progress
+func (e *Emulator) SetScenarios(s Scenarios) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.scenarios = s.Scenarios
+	e.steps = make(map[string]int)
+}
+
+// GetScenarios returns loaded scenarios
+func (e *Emulator) GetScenarios() Scenarios {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return Scenarios{Scenarios: append([]Scenario(nil), e.scenarios...)}
+}
+
+// NextStep returns the next scripted response of order.
+// Returns false if there is no scenario for the order
+func (e *Emulator) NextStep(number string) (Step, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i := range e.scenarios {
+		sc := &e.scenarios[i]
+		if !sc.match(number) {
+			continue
+		}
+		n := e.steps[number]
+		if n < len(sc.Responses)-1 {
+			e.steps[number] = n + 1
+		}
+		return sc.Responses[n], true
+	}
+	return Step{}, false
+}
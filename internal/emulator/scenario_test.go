@@ -0,0 +1,61 @@
+package emulator
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseScenarios(t *testing.T) {
+	tests := []struct {
+		name      string
+		scenarios string
+		wantErr   bool
+	}{
+		{"status", `{"scenarios": [{"order": "1", "responses": [{"status": "PROCESSED", "accrual": 10}]}]}`, false},
+		{"pattern", `{"scenarios": [{"pattern": "^7", "responses": [{"status": "INVALID"}]}]}`, false},
+		{"no content", `{"scenarios": [{"order": "1", "responses": [{"code": 204}]}]}`, false},
+		{"server error body", `{"scenarios": [{"order": "1", "responses": [{"code": 500, "body": "oops"}]}]}`, false},
+		{"no order", `{"scenarios": [{"responses": [{"status": "INVALID"}]}]}`, true},
+		{"bad pattern", `{"scenarios": [{"pattern": "(", "responses": [{"status": "INVALID"}]}]}`, true},
+		{"no responses", `{"scenarios": [{"order": "1"}]}`, true},
+		{"informational code", `{"scenarios": [{"order": "1", "responses": [{"code": 102}]}]}`, true},
+		{"bad code", `{"scenarios": [{"order": "1", "responses": [{"code": 600}]}]}`, true},
+		{"no content body", `{"scenarios": [{"order": "1", "responses": [{"code": 204, "body": "x"}]}]}`, true},
+		{"not modified body", `{"scenarios": [{"order": "1", "responses": [{"code": 304, "body": "x"}]}]}`, true},
+		{"bad delay", `{"scenarios": [{"order": "1", "responses": [{"delay": "soon"}]}]}`, true},
+		{"bad json", `{"scenarios": [`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseScenarios([]byte(tt.scenarios))
+			if tt.wantErr && !errors.Is(err, ErrBadRequest) {
+				t.Errorf("want ErrBadRequest, got %v", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %s", err.Error())
+			}
+		})
+	}
+}
+
+func TestNextStep(t *testing.T) {
+	s, err := ParseScenarios([]byte(`{"scenarios": [{"order": "1", "responses": [
+		{"status": "PROCESSING"}, {"code": 429}, {"status": "PROCESSED", "accrual": 10}]}]}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	e := New()
+	e.SetScenarios(s)
+
+	// the last response is repeated
+	want := []int{200, 429, 200, 200}
+	for i, code := range want {
+		step, ok := e.NextStep("1")
+		if !ok || step.Code != code {
+			t.Errorf("step %d: want code %d, got %d (%t)", i, code, step.Code, ok)
+		}
+	}
+	if _, ok := e.NextStep("2"); ok {
+		t.Error("order without scenario has a step")
+	}
+}
@@ -2,66 +2,184 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"math/rand"
+	"io/ioutil"
 	"net/http"
-	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/zklevsha/go-musthave-diploma/internal/config"
-	"github.com/zklevsha/go-musthave-diploma/internal/luhn"
+	"github.com/zklevsha/go-musthave-diploma/internal/emulator"
 	"github.com/zklevsha/go-musthave-diploma/internal/structs"
 )
 
-type accHandler struct{}
+type accHandler struct {
+	emulator *emulator.Emulator
+}
 
 func (a *accHandler) rootHandler(w http.ResponseWriter, r *http.Request) {
-	tooManyReq(w, r, 10)
 	resp := structs.Response{Message: "Server is working"}
 	sendResponse(w, r, http.StatusOK, resp)
 }
 
 func (a *accHandler) orderHandler(w http.ResponseWriter, r *http.Request) {
-	tooManyReq(w, r, 10)
 	v := mux.Vars(r)
-	order, err := strconv.Atoi(v["order"])
+	if step, ok := a.emulator.NextStep(v["order"]); ok {
+		sendStep(w, r, v["order"], step)
+		return
+	}
+	order, ok := a.emulator.GetOrder(v["order"])
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	sendResponse(w, r, http.StatusOK, order)
+}
+
+func (a *accHandler) registerOrderHandler(w http.ResponseWriter, r *http.Request) {
+	var order emulator.Order
+	if err := readJSON(r, &order); err != nil {
+		sendResponse(w, r, http.StatusBadRequest, structs.Response{Error: err.Error()})
+		return
+	}
+	err := a.emulator.RegisterOrder(order)
 	if err != nil {
-		sendResponse(w, r, http.StatusBadRequest,
-			structs.Response{Error: fmt.Sprintf("cant convert %s to int", v["order"])})
+		sendResponse(w, r, getAccErrStatusCode(err), structs.Response{Error: err.Error()})
 		return
 	}
-	if !luhn.Valid(order) {
-		sendResponse(w, r, http.StatusUnprocessableEntity, structs.Response{Error: "invalid order number"})
+	sendResponse(w, r, http.StatusAccepted, structs.Response{Message: "order was registered"})
+}
+
+func (a *accHandler) registerRewardHandler(w http.ResponseWriter, r *http.Request) {
+	var reward emulator.Reward
+	if err := readJSON(r, &reward); err != nil {
+		sendResponse(w, r, http.StatusBadRequest, structs.Response{Error: err.Error()})
 		return
 	}
+	err := a.emulator.RegisterReward(reward)
+	if err != nil {
+		sendResponse(w, r, getAccErrStatusCode(err), structs.Response{Error: err.Error()})
+		return
+	}
+	sendResponse(w, r, http.StatusOK, structs.Response{Message: "reward was registered"})
+}
+
+// sendStep sends scripted response of order
+func sendStep(w http.ResponseWriter, r *http.Request, number string, step emulator.Step) {
+	if d := step.GetDelay(); d > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(d):
+		}
+	}
+	for k, v := range step.Headers {
+		w.Header().Set(k, v)
+	}
+	switch {
+	case !emulator.BodyAllowed(step.Code):
+		// steps are validated by emulator.ParseScenarios, body is never sent with bodyless code
+		w.WriteHeader(step.Code)
+	case step.Body != nil:
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.WriteHeader(step.Code)
+		w.Write([]byte(*step.Body))
+	case step.Code == http.StatusOK:
+		sendResponse(w, r, step.Code,
+			structs.Order{Order: number, Status: step.Status, Accrual: step.Accrual})
+	default:
+		w.WriteHeader(step.Code)
+	}
+}
+
+func (a *accHandler) getScenariosHandler(w http.ResponseWriter, r *http.Request) {
+	sendResponse(w, r, http.StatusOK, a.emulator.GetScenarios())
+}
 
-	if rand.Intn(10)%2 == 0 {
-		accrual := float64(order % 359)
-		sendResponse(w, r, http.StatusOK,
-			structs.Order{Order: v["order"], Status: "PROCESSED", Accrual: &accrual})
+func (a *accHandler) loadScenariosHandler(w http.ResponseWriter, r *http.Request) {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		e := fmt.Sprintf("failed to read body: %s", err.Error())
+		sendResponse(w, r, http.StatusBadRequest, structs.Response{Error: e})
+		return
+	}
+	s, err := emulator.ParseScenarios(b)
+	if err != nil {
+		sendResponse(w, r, getAccErrStatusCode(err), structs.Response{Error: err.Error()})
 		return
 	}
+	a.emulator.SetScenarios(s)
+	msg := fmt.Sprintf("%d scenarios were loaded", len(s.Scenarios))
+	sendResponse(w, r, http.StatusOK, structs.Response{Message: msg})
+}
 
-	switch rand.Intn(2) {
-	case 0:
-		sendResponse(w, r, http.StatusOK,
-			structs.Order{Order: v["order"], Status: "REGISTERED"})
-	case 1:
-		sendResponse(w, r, http.StatusOK,
-			structs.Order{Order: v["order"], Status: "PROCESSING"})
-	case 2:
-		sendResponse(w, r, http.StatusOK,
-			structs.Order{Order: v["order"], Status: "INVALID"})
+func (a *accHandler) resetScenariosHandler(w http.ResponseWriter, r *http.Request) {
+	a.emulator.SetScenarios(emulator.Scenarios{})
+	sendResponse(w, r, http.StatusOK, structs.Response{Message: "scenarios were reset"})
+}
+
+func readJSON(r *http.Request, v interface{}) error {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read body: %s", err.Error())
+	}
+	err = json.Unmarshal(b, v)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal body: %s", err.Error())
 	}
+	return nil
 }
 
-func AccGetHandler(c config.AccrualConfig, ctx context.Context) http.Handler {
+func getAccErrStatusCode(err error) int {
+	switch {
+	case errors.Is(err, emulator.ErrBadRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, emulator.ErrOrderRegistered):
+		return http.StatusConflict
+	case errors.Is(err, emulator.ErrRewardRegistered):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func AccGetHandler(c config.AccrualConfig, ctx context.Context, e *emulator.Emulator) http.Handler {
 	r := mux.NewRouter()
-	a := accHandler{}
-	r.HandleFunc("/", a.rootHandler)
-	//
-	r.HandleFunc("/api/orders/{order}", a.orderHandler).
+	a := accHandler{emulator: e}
+	r.Handle("/", a.faultMiddleware(http.HandlerFunc(a.rootHandler)))
+
+	// rate limit is applied to accrual requests only (as in specification)
+	r.Handle("/api/orders/{order}",
+		a.rateLimitMiddleware(a.faultMiddleware(http.HandlerFunc(a.orderHandler)))).
+		Methods("GET")
+	r.Handle("/api/orders", a.faultMiddleware(http.HandlerFunc(a.registerOrderHandler))).
+		Methods("POST")
+	r.Handle("/api/goods", a.faultMiddleware(http.HandlerFunc(a.registerRewardHandler))).
+		Methods("POST")
+
+	// fault injection
+	r.HandleFunc("/admin/faults", a.getFaultsHandler).
+		Methods("GET")
+	r.HandleFunc("/admin/faults", a.setFaultsHandler).
+		Methods("PUT")
+
+	// rate limit
+	r.HandleFunc("/admin/ratelimit", a.getRateLimitHandler).
+		Methods("GET")
+	r.HandleFunc("/admin/ratelimit", a.setRateLimitHandler).
+		Methods("PUT")
+
+	// scenarios
+	r.HandleFunc("/admin/scenarios", a.getScenariosHandler).
 		Methods("GET")
+	r.HandleFunc("/admin/scenarios", a.loadScenariosHandler).
+		Methods("PUT")
+	r.HandleFunc("/admin/scenarios", a.resetScenariosHandler).
+		Methods("DELETE")
 
 	return r
 }
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/hash"
+	"github.com/zklevsha/go-musthave-diploma/internal/processor"
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+// SignatureHeader contains HMAC-SHA256 (hex) of callback body signed with accrual callback key
+const SignatureHeader = "X-Signature"
+
+// accrualCallbackHandler applies order result pushed by accrual system.
+// Body has the same format as accrual system GET /api/orders/{number} response
+func (h *Handler) accrualCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	// RequestCtxBody{} should be set in readBody middleware
+	body := r.Context().Value(structs.RequestCtxBody{}).([]byte)
+
+	ok, err := hash.Verify(string(body), h.callbackKey, r.Header.Get(SignatureHeader))
+	if err != nil || !ok {
+		sendResponse(w, r, http.StatusUnauthorized, structs.Response{Error: "bad signature"})
+		return
+	}
+
+	var order structs.Order
+	err = json.Unmarshal(body, &order)
+	if err != nil {
+		e := fmt.Sprintf("failed to unmarshal body: %s", err.Error())
+		sendResponse(w, r, http.StatusBadRequest, structs.Response{Error: e})
+		return
+	}
+	orderID, err := strconv.Atoi(order.Order)
+	if err != nil {
+		e := fmt.Sprintf("bad order number %q", order.Order)
+		sendResponse(w, r, http.StatusBadRequest, structs.Response{Error: e})
+		return
+	}
+	order.Raw = body
+
+	err = processor.ApplyOrder(h.Storage, orderID, order, structs.SourceCallback)
+	if err != nil {
+		e := fmt.Sprintf("failed to apply order result: %s", err.Error())
+		sendResponse(w, r, getErrStatusCode(err), structs.Response{Error: e})
+		return
+	}
+	sendResponse(w, r, http.StatusOK, structs.Response{Message: "order result was applied"})
+}
@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/accrual"
+	"github.com/zklevsha/go-musthave-diploma/internal/cassette"
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+// skipHeaders are not forwarded by proxy and not replayed
+var skipHeaders = map[string]bool{
+	"Connection":        true,
+	"Keep-Alive":        true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+	"Content-Length":    true,
+	// bodies are recorded decoded
+	"Accept-Encoding":  true,
+	"Content-Encoding": true,
+}
+
+func copyHeaders(dst, src http.Header) {
+	for k, v := range src {
+		if skipHeaders[http.CanonicalHeaderKey(k)] {
+			continue
+		}
+		dst[k] = append([]string(nil), v...)
+	}
+}
+
+type recordHandler struct {
+	upstream string
+	client   *http.Client
+	recorder *cassette.Recorder
+}
+
+// ServeHTTP forwards request to upstream accrual system and records response
+func (h *recordHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		e := fmt.Sprintf("failed to read body: %s", err.Error())
+		sendResponse(w, r, http.StatusBadRequest, structs.Response{Error: e})
+		return
+	}
+	req, err := http.NewRequestWithContext(r.Context(), r.Method,
+		h.upstream+r.URL.RequestURI(), bytes.NewReader(reqBody))
+	if err != nil {
+		e := fmt.Sprintf("failed to create upstream request: %s", err.Error())
+		sendResponse(w, r, http.StatusInternalServerError, structs.Response{Error: e})
+		return
+	}
+	copyHeaders(req.Header, r.Header)
+
+	entry := cassette.Entry{
+		Time:        time.Now().UTC(),
+		Method:      r.Method,
+		Path:        r.URL.RequestURI(),
+		RequestBody: string(reqBody),
+	}
+	start := time.Now()
+	resp, err := h.client.Do(req)
+	var body []byte
+	if err == nil {
+		body, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	entry.Duration = time.Since(start).String()
+
+	if err != nil {
+		log.Printf("WARN proxy %s %s failed: %s", r.Method, entry.Path, err.Error())
+		entry.Error = err.Error()
+		h.record(entry)
+		resetConn(w)
+		return
+	}
+	entry.Code = resp.StatusCode
+	entry.Headers = make(http.Header)
+	copyHeaders(entry.Headers, resp.Header)
+	entry.Body = string(body)
+	h.record(entry)
+
+	copyHeaders(w.Header(), resp.Header)
+	w.WriteHeader(resp.StatusCode)
+	w.Write(body)
+}
+
+func (h *recordHandler) record(e cassette.Entry) {
+	if err := h.recorder.Record(e); err != nil {
+		log.Printf("ERROR proxy %s", err.Error())
+	}
+}
+
+type replayHandler struct {
+	player *cassette.Player
+}
+
+// ServeHTTP serves recorded response of request
+func (h *replayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e, err := h.player.Next(r.Method, r.URL.RequestURI())
+	if err != nil {
+		log.Printf("WARN replay %s", err.Error())
+		code := http.StatusInternalServerError
+		if errors.Is(err, cassette.ErrNotRecorded) {
+			code = http.StatusNotFound
+		}
+		sendResponse(w, r, code, structs.Response{Error: err.Error()})
+		return
+	}
+	if d := e.GetDuration(); d > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(d):
+		}
+	}
+	if e.Code == 0 {
+		resetConn(w)
+		return
+	}
+	copyHeaders(w.Header(), e.Headers)
+	w.WriteHeader(e.Code)
+	w.Write([]byte(e.Body))
+}
+
+// AccRecordHandler returns handler which proxies all requests to upstream
+// accrual system (URL, timeout and TLS settings of upstream) and records them to cassette.
+// Upstream request is canceled when client gives up, so client timeouts are recorded as well
+func AccRecordHandler(upstream accrual.Config, recorder *cassette.Recorder) (http.Handler, error) {
+	client, err := accrual.NewClient(upstream)
+	if err != nil {
+		return nil, err
+	}
+	return &recordHandler{
+		upstream: strings.TrimSuffix(upstream.URL, "/"),
+		client:   client,
+		recorder: recorder,
+	}, nil
+}
+
+// AccReplayHandler returns handler which serves responses recorded to cassette
+func AccReplayHandler(player *cassette.Player) http.Handler {
+	return &replayHandler{player: player}
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/emulator"
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+// malformedBody is sent instead of successful response body on 'malformed' fault
+const malformedBody = `{"order": "0", "status": PROCESSED, "accrual": }`
+
+// responseRecorder keeps response, so it can be corrupted before sending
+type responseRecorder struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) Header() http.Header {
+	return rr.header
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	return rr.body.Write(b)
+}
+
+func (rr *responseRecorder) WriteHeader(code int) {
+	rr.code = code
+}
+
+// faultMiddleware injects faults configured in emulator
+func (a *accHandler) faultMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f := a.emulator.GetFaults()
+		if d := f.Delay(); d > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(d):
+			}
+		}
+
+		switch f.Roll() {
+		case emulator.FaultConnReset:
+			resetConn(w)
+			return
+		case emulator.FaultServerError:
+			sendResponse(w, r, http.StatusInternalServerError,
+				structs.Response{Error: "internal server error (injected)"})
+			return
+		case emulator.FaultTooManyRequests:
+			tooManyRequests(w, f.GetRetryAfter(), a.emulator.RateLimit())
+			return
+		}
+
+		rr := &responseRecorder{header: w.Header(), code: http.StatusOK}
+		next.ServeHTTP(rr, r)
+		body := rr.body.Bytes()
+		if rr.code == http.StatusOK {
+			switch f.RollBody() {
+			case emulator.FaultTruncated:
+				body = body[:len(body)/2]
+			case emulator.FaultMalformed:
+				body = []byte(malformedBody)
+			}
+		}
+		// body could be changed
+		w.Header().Del("Content-Length")
+		w.WriteHeader(rr.code)
+		w.Write(body)
+	})
+}
+
+// resetConn closes client connection without response (TCP RST if possible)
+func resetConn(w http.ResponseWriter) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		log.Println("WARN faults connection can`t be hijacked. Reset is not injected")
+		return
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		log.Printf("WARN faults failed to hijack connection: %s", err.Error())
+		return
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+func (a *accHandler) getFaultsHandler(w http.ResponseWriter, r *http.Request) {
+	sendResponse(w, r, http.StatusOK, a.emulator.GetFaults())
+}
+
+func (a *accHandler) setFaultsHandler(w http.ResponseWriter, r *http.Request) {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		e := fmt.Sprintf("failed to read body: %s", err.Error())
+		sendResponse(w, r, http.StatusBadRequest, structs.Response{Error: e})
+		return
+	}
+	f, err := emulator.ParseFaults(b)
+	if err != nil {
+		sendResponse(w, r, getAccErrStatusCode(err), structs.Response{Error: err.Error()})
+		return
+	}
+	a.emulator.SetFaults(f)
+	sendResponse(w, r, http.StatusOK, structs.Response{Message: "faults were set"})
+}
@@ -3,11 +3,15 @@ package handler
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/zklevsha/go-musthave-diploma/internal/archive"
@@ -19,9 +23,19 @@ import (
 	"github.com/zklevsha/go-musthave-diploma/internal/structs"
 )
 
+const maxIdempotencyKeyLen = 255
+
 type Handler struct {
-	Storage interfaces.Storage
-	key     string
+	Storage      interfaces.Storage
+	key          string
+	passwordCost int
+	callbackKey  string
+	keyring      *jwt.Keyring
+	accessTTL    time.Duration
+	refreshTTL   time.Duration
+	// dummyHash is compared with password of unknown user,
+	// so login response time does not reveal registered logins
+	dummyHash string
 }
 
 func (h *Handler) rootHandler(w http.ResponseWriter, r *http.Request) {
@@ -43,24 +57,20 @@ func (h *Handler) registerHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Creating user
-	hashedPwd := hash.Sign(h.key, creds.Password)
-	id, err := h.Storage.Register(creds.Login, hashedPwd)
+	hashedPwd, err := hash.HashPassword(creds.Password, h.passwordCost)
 	if err != nil {
-		sendResponse(w, r, getErrStatusCode(err),
-			structs.Response{Error: err.Error()})
+		e := fmt.Sprintf("failed to hash password: %s", err.Error())
+		sendResponse(w, r, http.StatusInternalServerError, structs.Response{Error: e})
 		return
 	}
-
-	// Generating jwt
-	token, err := jwt.Generate(id, h.key)
+	id, err := h.Storage.Register(creds.Login, hashedPwd)
 	if err != nil {
-		e := fmt.Sprintf("failed to generate jwt token: %s", err.Error())
-		sendResponse(w, r, http.StatusBadRequest, structs.Response{Error: e})
+		sendResponse(w, r, getErrStatusCode(err),
+			structs.Response{Error: err.Error()})
 		return
 	}
 
-	w.Header().Set("Authorization", fmt.Sprintf("Bearer %s", token))
-	sendResponse(w, r, http.StatusOK, structs.Response{Message: "user was created"})
+	h.issueTokens(w, r, id, "user was created")
 }
 
 func (h *Handler) loginHandler(w http.ResponseWriter, r *http.Request) {
@@ -74,21 +84,48 @@ func (h *Handler) loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, err := h.Storage.GetUserID(creds)
+	user, err := h.Storage.GetUser(creds.Login)
+	if errors.Is(err, structs.ErrUserAuth) {
+		_, _, _ = hash.CheckPassword(creds.Password, h.dummyHash, h.key, h.passwordCost)
+	}
 	if err != nil {
 		e := fmt.Sprintf("failed to authenticate user: %s", err.Error())
 		sendResponse(w, r, getErrStatusCode(err), structs.Response{Error: e})
 		return
 	}
 
-	token, err := jwt.Generate(id, h.key)
+	ok, needRehash, err := hash.CheckPassword(creds.Password, user.Password, h.key, h.passwordCost)
 	if err != nil {
-		e := fmt.Sprintf("failed to generate jwt token: %s", err.Error())
-		sendResponse(w, r, http.StatusBadRequest, structs.Response{Error: e})
+		e := fmt.Sprintf("failed to check password: %s", err.Error())
+		sendResponse(w, r, http.StatusInternalServerError, structs.Response{Error: e})
+		return
+	}
+	if !ok {
+		e := fmt.Sprintf("failed to authenticate user: %s", structs.ErrUserAuth.Error())
+		sendResponse(w, r, http.StatusUnauthorized, structs.Response{Error: e})
 		return
 	}
-	w.Header().Set("Authorization", fmt.Sprintf("Bearer %s", token))
-	sendResponse(w, r, http.StatusOK, structs.Response{Message: "Authentication successful"})
+	if needRehash {
+		h.rehashPassword(user.ID, creds.Password)
+	}
+
+	h.issueTokens(w, r, user.ID, "Authentication successful")
+}
+
+// rehashPassword replaces legacy (or created with outdated cost) password hash.
+// Failure is not critical for login, so it is only logged
+func (h *Handler) rehashPassword(userid int, password string) {
+	hashed, err := hash.HashPassword(password, h.passwordCost)
+	if err != nil {
+		log.Printf("WARN handler failed to rehash password of user %d: %s", userid, err.Error())
+		return
+	}
+	_, err = h.Storage.SetUserPassword(userid, hashed)
+	if err != nil {
+		log.Printf("WARN handler failed to store new password hash of user %d: %s", userid, err.Error())
+		return
+	}
+	log.Printf("INFO handler password hash of user %d was upgraded", userid)
 }
 
 func (h *Handler) createOrderHandler(w http.ResponseWriter, r *http.Request) {
@@ -125,7 +162,12 @@ func (h *Handler) createOrderHandler(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) getOrdersHandler(w http.ResponseWriter, r *http.Request) {
 	// RequestCtxUserID{} should be set in authentication middleware
 	userid := r.Context().Value(structs.RequestCtxUserID{}).(int)
-	orders, err := h.Storage.GetOrders(userid)
+	filter, err := getListFilter(r, true)
+	if err != nil {
+		sendResponse(w, r, http.StatusBadRequest, structs.Response{Error: err.Error()})
+		return
+	}
+	orders, next, err := h.Storage.GetOrders(userid, filter)
 	if err != nil {
 		e := fmt.Sprintf("cant get orders: %s", err.Error())
 		sendResponse(w, r, http.StatusBadRequest, structs.Response{Error: e})
@@ -133,11 +175,31 @@ func (h *Handler) getOrdersHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	if len(orders) == 0 {
 		sendResponse(w, r, http.StatusNoContent, structs.Response{Message: "no orders were found"})
+		return
 	}
+	setNextCursor(w, next)
 	sendResponse(w, r, http.StatusOK, orders)
 
 }
 
+func (h *Handler) getOrderHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	// RequestCtxUserID{} should be set in authentication middleware
+	userid := r.Context().Value(structs.RequestCtxUserID{}).(int)
+	v := mux.Vars(r)
+	orderid, err := strconv.Atoi(v["number"])
+	if err != nil {
+		sendResponse(w, r, http.StatusBadRequest, structs.Response{Error: "invalid order number"})
+		return
+	}
+	events, err := h.Storage.GetOrderHistory(userid, orderid)
+	if err != nil {
+		e := fmt.Sprintf("cant get order history: %s", err.Error())
+		sendResponse(w, r, getErrStatusCode(err), structs.Response{Error: e})
+		return
+	}
+	sendResponse(w, r, http.StatusOK, events)
+}
+
 func (h *Handler) getBalanceHandler(w http.ResponseWriter, r *http.Request) {
 	// RequestCtxUserID{} should be set in authentication middleware
 	userid := r.Context().Value(structs.RequestCtxUserID{}).(int)
@@ -154,56 +216,120 @@ func (h *Handler) withdrawHandler(w http.ResponseWriter, r *http.Request) {
 	// RequestCtxBody{} should be set in read body middleware
 	body := r.Context().Value(structs.RequestCtxBody{}).([]byte)
 
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		code, resp := h.withdraw(userid, body, nil)
+		sendResponse(w, r, code, resp)
+		return
+	}
+	if len(key) > maxIdempotencyKeyLen {
+		e := fmt.Sprintf("Idempotency-Key is longer than %d characters", maxIdempotencyKeyLen)
+		sendResponse(w, r, http.StatusBadRequest, structs.Response{Error: e})
+		return
+	}
+
+	requestHash := fmt.Sprintf("%x", sha256.Sum256(body))
+	rec, reserved, err := h.Storage.ReserveIdempotencyKey(userid, key, requestHash)
+	if err != nil {
+		e := fmt.Sprintf("failed to check idempotency key: %s", err.Error())
+		sendResponse(w, r, http.StatusInternalServerError, structs.Response{Error: e})
+		return
+	}
+	if !reserved {
+		switch {
+		case rec.RequestHash != requestHash:
+			sendResponse(w, r, http.StatusUnprocessableEntity,
+				structs.Response{Error: "Idempotency-Key was already used with a different request"})
+		case rec.StatusCode == 0:
+			sendResponse(w, r, http.StatusConflict,
+				structs.Response{Error: "request with this Idempotency-Key is in progress"})
+		default:
+			w.Header().Set("Idempotent-Replayed", "true")
+			sendResponse(w, r, rec.StatusCode, json.RawMessage(rec.Response))
+		}
+		return
+	}
+
+	code, resp := h.withdraw(userid, body, &rec)
+	switch {
+	case code == http.StatusOK:
+		// successful response is stored with withdrawal
+	case code >= http.StatusInternalServerError:
+		// server errors are not stored, so the client can retry
+		err = h.Storage.DeleteIdempotencyKey(userid, rec)
+	default:
+		rec.StatusCode = code
+		rec.Response, err = json.Marshal(resp)
+		if err == nil {
+			err = h.Storage.SaveIdempotencyResponse(userid, rec)
+		}
+	}
+	switch {
+	case errors.Is(err, structs.ErrIdempotencyKeyLost):
+		log.Printf("WARN handler request with idempotency key %s was taken over by retry, "+
+			"its result is not stored", key)
+	case err != nil:
+		log.Printf("ERROR handler failed to store result of request with idempotency key %s: %s",
+			key, err.Error())
+	}
+	sendResponse(w, r, code, resp)
+}
+
+// withdraw processes withdraw request and returns response status code and body.
+// If idempotency key is reserved, successful response is stored with withdrawal
+func (h *Handler) withdraw(userid int, body []byte, reservation *structs.IdempotencyRecord) (int, structs.Response) {
 	var withdraw structs.Withdraw
 	err := json.NewDecoder(bytes.NewReader(body)).Decode(&withdraw)
 	if err != nil {
 		e := fmt.Sprintf("failed to decode request body: %s", err.Error())
-		sendResponse(w, r, http.StatusBadRequest, structs.Response{Error: e})
-		return
+		return http.StatusBadRequest, structs.Response{Error: e}
 	}
 
 	orderid, err := strconv.Atoi(string(withdraw.Order))
 	if err != nil {
-		sendResponse(w, r, http.StatusBadRequest, structs.Response{Error: "invalid order value"})
+		return http.StatusBadRequest, structs.Response{Error: "invalid order value"}
 	}
 
 	if !luhn.Valid(orderid) {
-		sendResponse(w, r, http.StatusUnprocessableEntity, structs.Response{Error: "invalid order value"})
-		return
+		return http.StatusUnprocessableEntity, structs.Response{Error: "invalid order value"}
 	}
 
-	balance, err := h.Storage.GetUserBalance(userid)
-	if err != nil {
-		e := fmt.Sprintf("failed to get users`s balance: %s", err.Error())
-		sendResponse(w, r, http.StatusInternalServerError, structs.Response{Error: e})
-		return
+	if withdraw.Sum <= 0 {
+		return http.StatusBadRequest, structs.Response{Error: "invalid sum value"}
 	}
 
-	if balance.Current < withdraw.Sum {
-		e := fmt.Sprintf("winthdraw amount exceeds current balance (%f)", balance.Current)
-		sendResponse(w, r, http.StatusPaymentRequired, structs.Response{Error: e})
-		return
+	resp := structs.Response{Message: "withdraw reqest was proccessed"}
+	var idempotency *structs.IdempotencyRecord
+	if reservation != nil {
+		stored, err := json.Marshal(resp)
+		if err != nil {
+			e := fmt.Sprintf("failed to encode response: %s", err.Error())
+			return http.StatusInternalServerError, structs.Response{Error: e}
+		}
+		rec := *reservation
+		rec.StatusCode, rec.Response = http.StatusOK, stored
+		idempotency = &rec
 	}
 
-	err = h.Storage.Withdraw(userid, withdraw)
+	// balance check and withdrawal are done by storage in a single transaction
+	err = h.Storage.Withdraw(userid, withdraw, idempotency)
 	if err != nil {
 		e := fmt.Sprintf("failed to withdraw: %s", err.Error())
-		sendResponse(w, r, http.StatusInternalServerError, structs.Response{Error: e})
-		return
+		return getErrStatusCode(err), structs.Response{Error: e}
 	}
 
-	sendResponse(w, r, http.StatusOK,
-		structs.Response{Message: "withdraw reqest was proccessed"})
+	return http.StatusOK, resp
 }
 
 func (h *Handler) getWithdrawalsHandler(w http.ResponseWriter, r *http.Request) {
-	userid, err := TokenGetUserID(r, h.key)
+	// RequestCtxUserID{} should be set in authentication middleware
+	userid := r.Context().Value(structs.RequestCtxUserID{}).(int)
+	filter, err := getListFilter(r, false)
 	if err != nil {
-		e := fmt.Sprintf("authentication failure: %s", err.Error())
-		sendResponse(w, r, http.StatusUnauthorized, structs.Response{Error: e})
+		sendResponse(w, r, http.StatusBadRequest, structs.Response{Error: err.Error()})
 		return
 	}
-	withdrawals, err := h.Storage.GetWithdrawls(userid)
+	withdrawals, next, err := h.Storage.GetWithdrawls(userid, filter)
 	if err != nil {
 		e := fmt.Sprintf("cant get withdrawals: %s", err.Error())
 		sendResponse(w, r, http.StatusBadRequest, structs.Response{Error: e})
@@ -212,20 +338,37 @@ func (h *Handler) getWithdrawalsHandler(w http.ResponseWriter, r *http.Request)
 	if len(withdrawals) == 0 {
 		sendResponse(w, r, http.StatusNoContent,
 			structs.Response{Message: "no withdrawals were found"})
+		return
 	}
+	setNextCursor(w, next)
 	sendResponse(w, r, http.StatusOK, withdrawals)
 
 }
 
 func (h *Handler) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		uid, err := TokenGetUserID(r, h.key)
+		claims, err := TokenGetClaims(r, h.keyring)
 		if err != nil {
 			e := fmt.Sprintf("authentication failure: %s", err.Error())
 			sendResponse(w, r, http.StatusUnauthorized, structs.Response{Error: e})
 			return
 		}
-		ctx := context.WithValue(r.Context(), structs.RequestCtxUserID{}, uid)
+		// legacy tokens have no id and can`t be revoked
+		if claims.ID != "" {
+			revoked, err := h.Storage.IsTokenRevoked(claims.ID)
+			if err != nil {
+				e := fmt.Sprintf("failed to check token: %s", err.Error())
+				sendResponse(w, r, http.StatusInternalServerError, structs.Response{Error: e})
+				return
+			}
+			if revoked {
+				e := fmt.Sprintf("authentication failure: %s", structs.ErrTokenRevoked.Error())
+				sendResponse(w, r, http.StatusUnauthorized, structs.Response{Error: e})
+				return
+			}
+		}
+		ctx := context.WithValue(r.Context(), structs.RequestCtxUserID{}, claims.UserID)
+		ctx = context.WithValue(ctx, structs.RequestCtxClaims{}, claims)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -252,9 +395,16 @@ func (h *Handler) readBodyMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func GetHandler(c config.ServerConfig, ctx context.Context, store interfaces.Storage) http.Handler {
+func GetHandler(c config.ServerConfig, ctx context.Context, store interfaces.Storage,
+	keyring *jwt.Keyring) http.Handler {
 	r := mux.NewRouter()
-	h := Handler{Storage: store, key: c.Key}
+	h := Handler{Storage: store, key: c.Key, passwordCost: c.PasswordCost,
+		callbackKey: c.AccrualCallbackKey, keyring: keyring, accessTTL: c.AccessTokenTTL, refreshTTL: c.RefreshTokenTTL}
+	dummyHash, err := hash.HashPassword("dummy", c.PasswordCost)
+	if err != nil {
+		log.Printf("WARN failed to create dummy password hash: %s", err.Error())
+	}
+	h.dummyHash = dummyHash
 
 	// root
 	chain := h.authMiddleware(http.HandlerFunc(h.rootHandler))
@@ -272,6 +422,21 @@ func GetHandler(c config.ServerConfig, ctx context.Context, store interfaces.Sto
 		Methods("POST").
 		Headers("Content-Type", "application/json")
 
+	// public keys of token signing keys
+	r.HandleFunc("/.well-known/jwks.json", h.jwksHandler).
+		Methods("GET")
+
+	// refresh tokens
+	chain = h.readBodyMiddleware(http.HandlerFunc(h.refreshHandler))
+	r.Handle("/api/user/token/refresh", chain).
+		Methods("POST").
+		Headers("Content-Type", "application/json")
+
+	// logout
+	chain = h.authMiddleware(http.HandlerFunc(h.logoutHandler))
+	r.Handle("/api/user/logout", chain).
+		Methods("POST")
+
 	// create order
 	chain = h.authMiddleware(
 		h.readBodyMiddleware(http.HandlerFunc(h.createOrderHandler)))
@@ -284,6 +449,11 @@ func GetHandler(c config.ServerConfig, ctx context.Context, store interfaces.Sto
 	r.Handle("/api/user/orders", chain).
 		Methods("GET")
 
+	// get order status history
+	chain = h.authMiddleware(http.HandlerFunc(h.getOrderHistoryHandler))
+	r.Handle("/api/user/orders/{number}/history", chain).
+		Methods("GET")
+
 	// get balance
 	chain = h.authMiddleware(http.HandlerFunc(h.getBalanceHandler))
 	r.Handle("/api/user/balance", chain).
@@ -300,5 +470,13 @@ func GetHandler(c config.ServerConfig, ctx context.Context, store interfaces.Sto
 	chain = h.authMiddleware(http.HandlerFunc(h.getWithdrawalsHandler))
 	r.Handle("/api/user/withdrawals", chain).
 		Methods("GET")
+
+	// accrual system callback (push mode)
+	if h.callbackKey != "" {
+		chain = h.readBodyMiddleware(http.HandlerFunc(h.accrualCallbackHandler))
+		r.Handle("/api/accrual/callback", chain).
+			Methods("POST").
+			Headers("Content-Type", "application/json")
+	}
 	return r
 }
@@ -0,0 +1,393 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/config"
+	"github.com/zklevsha/go-musthave-diploma/internal/hash"
+	"github.com/zklevsha/go-musthave-diploma/internal/jwt"
+	"github.com/zklevsha/go-musthave-diploma/internal/memstorage"
+	"github.com/zklevsha/go-musthave-diploma/internal/money"
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+const testKey = "key"
+
+type testServer struct {
+	*httptest.Server
+	storage *memstorage.MemStorage
+}
+
+func newTestServer(t *testing.T) testServer {
+	t.Helper()
+	s := &memstorage.MemStorage{}
+	if err := s.Init(); err != nil {
+		t.Fatalf("failed to init storage: %s", err.Error())
+	}
+	t.Cleanup(s.Close)
+	keyring, err := jwt.NewKeyring([]jwt.Key{{ID: jwt.DefaultKeyID, Secret: "secret"}}, jwt.DefaultKeyID, testKey)
+	if err != nil {
+		t.Fatalf("failed to create keyring: %s", err.Error())
+	}
+	c := config.ServerConfig{Key: testKey, PasswordCost: bcrypt.MinCost,
+		AccessTokenTTL: time.Minute, RefreshTokenTTL: time.Hour}
+	srv := httptest.NewServer(GetHandler(c, context.Background(), s, keyring))
+	t.Cleanup(srv.Close)
+	return testServer{Server: srv, storage: s}
+}
+
+type testRequest struct {
+	method      string
+	path        string
+	token       string
+	contentType string
+	body        string
+	header      map[string]string
+}
+
+func (s testServer) do(t *testing.T, req testRequest) (*http.Response, []byte) {
+	t.Helper()
+	r, err := http.NewRequest(req.method, s.URL+req.path, strings.NewReader(req.body))
+	if err != nil {
+		t.Fatalf("failed to create request: %s", err.Error())
+	}
+	if req.contentType != "" {
+		r.Header.Set("Content-Type", req.contentType)
+	}
+	if req.token != "" {
+		r.Header.Set("Authorization", "Bearer "+req.token)
+	}
+	for k, v := range req.header {
+		r.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("request %s %s failed: %s", req.method, req.path, err.Error())
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response body: %s", err.Error())
+	}
+	return resp, body
+}
+
+// auth registers (or logs in) user and returns issued tokens
+func (s testServer) auth(t *testing.T, path string, login string, password string) (int, structs.TokenResponse) {
+	t.Helper()
+	body, _ := json.Marshal(structs.Credentials{Login: login, Password: password})
+	resp, b := s.do(t, testRequest{method: "POST", path: path,
+		contentType: "application/json", body: string(body)})
+	var tokens structs.TokenResponse
+	if resp.StatusCode == http.StatusOK {
+		if err := json.Unmarshal(b, &tokens); err != nil {
+			t.Fatalf("failed to decode tokens: %s", err.Error())
+		}
+	}
+	return resp.StatusCode, tokens
+}
+
+func (s testServer) register(t *testing.T, login string) (int, string) {
+	t.Helper()
+	code, tokens := s.auth(t, "/api/user/register", login, "password")
+	if code != http.StatusOK {
+		t.Fatalf("failed to register user: status %d", code)
+	}
+	user, err := s.storage.GetUser(login)
+	if err != nil {
+		t.Fatalf("failed to get user: %s", err.Error())
+	}
+	return user.ID, tokens.AccessToken
+}
+
+// credit adds points to balance of user
+func (s testServer) credit(t *testing.T, userid int, amount string) {
+	t.Helper()
+	a, err := money.Parse(amount)
+	if err != nil {
+		t.Fatalf("bad amount: %s", err.Error())
+	}
+	_, err = s.storage.AddLedgerEntry(userid, structs.LedgerEntry{Type: structs.LedgerAdjustment,
+		Amount: a, Reference: "test"})
+	if err != nil {
+		t.Fatalf("failed to credit user: %s", err.Error())
+	}
+}
+
+func (s testServer) balance(t *testing.T, token string) structs.Balance {
+	t.Helper()
+	resp, b := s.do(t, testRequest{method: "GET", path: "/api/user/balance", token: token})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get balance: status %d", resp.StatusCode)
+	}
+	var balance structs.Balance
+	if err := json.Unmarshal(b, &balance); err != nil {
+		t.Fatalf("failed to decode balance: %s", err.Error())
+	}
+	return balance
+}
+
+func withdrawRequest(token string, order string, sum string) testRequest {
+	return testRequest{method: "POST", path: "/api/user/balance/withdraw", token: token,
+		contentType: "application/json", body: `{"order": "` + order + `", "sum": ` + sum + `}`}
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	s := newTestServer(t)
+	s.register(t, "user")
+
+	tests := []struct {
+		name     string
+		path     string
+		login    string
+		password string
+		want     int
+	}{
+		{"duplicate login", "/api/user/register", "user", "other", http.StatusConflict},
+		{"login", "/api/user/login", "user", "password", http.StatusOK},
+		{"wrong password", "/api/user/login", "user", "other", http.StatusUnauthorized},
+		{"unknown login", "/api/user/login", "nobody", "password", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, tokens := s.auth(t, tt.path, tt.login, tt.password)
+			if code != tt.want {
+				t.Fatalf("want status %d, got %d", tt.want, code)
+			}
+			if code != http.StatusOK {
+				return
+			}
+			resp, _ := s.do(t, testRequest{method: "GET", path: "/", token: tokens.AccessToken})
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("issued token is not accepted: status %d", resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestLoginRehashesPassword(t *testing.T) {
+	oldCost, err := hash.HashPassword("password", bcrypt.MinCost+1)
+	if err != nil {
+		t.Fatalf("failed to hash password: %s", err.Error())
+	}
+	tests := []struct {
+		name   string
+		stored string
+	}{
+		{"legacy hash", hash.Sign(testKey, "password")},
+		{"outdated cost", oldCost},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			if _, err := s.storage.Register("user", tt.stored); err != nil {
+				t.Fatalf("failed to register user: %s", err.Error())
+			}
+
+			if code, _ := s.auth(t, "/api/user/login", "user", "password"); code != http.StatusOK {
+				t.Fatalf("want status 200, got %d", code)
+			}
+			user, err := s.storage.GetUser("user")
+			if err != nil {
+				t.Fatalf("failed to get user: %s", err.Error())
+			}
+			cost, err := bcrypt.Cost([]byte(user.Password))
+			if err != nil || cost != bcrypt.MinCost {
+				t.Errorf("password is not rehashed: %s", user.Password)
+			}
+			// new hash is accepted
+			if code, _ := s.auth(t, "/api/user/login", "user", "password"); code != http.StatusOK {
+				t.Errorf("want status 200 after rehash, got %d", code)
+			}
+		})
+	}
+}
+
+func TestWithdraw(t *testing.T) {
+	s := newTestServer(t)
+	userid, token := s.register(t, "user")
+	s.credit(t, userid, "100")
+
+	// insufficient funds do not change anything
+	resp, _ := s.do(t, withdrawRequest(token, "12345678903", "100.01"))
+	if resp.StatusCode != http.StatusPaymentRequired {
+		t.Fatalf("want status 402, got %d", resp.StatusCode)
+	}
+	if b := s.balance(t, token); b.Current.String() != "100" || b.Withdrawn.String() != "0" {
+		t.Errorf("balance is changed by rejected withdrawal: %+v", b)
+	}
+	resp, _ = s.do(t, testRequest{method: "GET", path: "/api/user/withdrawals", token: token})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("rejected withdrawal is listed: status %d", resp.StatusCode)
+	}
+
+	resp, _ = s.do(t, withdrawRequest(token, "12345678903", "99.99"))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("want status 200, got %d", resp.StatusCode)
+	}
+	if b := s.balance(t, token); b.Current.String() != "0.01" || b.Withdrawn.String() != "99.99" {
+		t.Errorf("unexpected balance: %+v", b)
+	}
+}
+
+func TestWithdrawIdempotencyKey(t *testing.T) {
+	s := newTestServer(t)
+	userid, token := s.register(t, "user")
+	s.credit(t, userid, "100")
+
+	withKey := func(req testRequest, key string) testRequest {
+		req.header = map[string]string{"Idempotency-Key": key}
+		return req
+	}
+	tests := []struct {
+		name     string
+		req      testRequest
+		want     int
+		replayed bool
+	}{
+		{"withdraw", withKey(withdrawRequest(token, "12345678903", "60"), "a"), http.StatusOK, false},
+		{"retry", withKey(withdrawRequest(token, "12345678903", "60"), "a"), http.StatusOK, true},
+		{"different body", withKey(withdrawRequest(token, "12345678903", "10"), "a"),
+			http.StatusUnprocessableEntity, false},
+		{"insufficient funds", withKey(withdrawRequest(token, "79927398713", "60"), "b"),
+			http.StatusPaymentRequired, false},
+		{"insufficient funds retry", withKey(withdrawRequest(token, "79927398713", "60"), "b"),
+			http.StatusPaymentRequired, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := s.do(t, tt.req)
+			if resp.StatusCode != tt.want {
+				t.Errorf("want status %d, got %d", tt.want, resp.StatusCode)
+			}
+			if replayed := resp.Header.Get("Idempotent-Replayed") == "true"; replayed != tt.replayed {
+				t.Errorf("want replayed %t, got %t", tt.replayed, replayed)
+			}
+		})
+	}
+
+	// retries do not withdraw twice
+	if b := s.balance(t, token); b.Current.String() != "40" || b.Withdrawn.String() != "60" {
+		t.Errorf("unexpected balance: %+v", b)
+	}
+}
+
+func TestGetOrdersPagination(t *testing.T) {
+	s := newTestServer(t)
+	_, token := s.register(t, "user")
+	// orders uploaded within the same second are ordered by number
+	want := []string{"12345678903", "49927398716", "79927398713"}
+	for _, order := range want {
+		resp, _ := s.do(t, testRequest{method: "POST", path: "/api/user/orders", token: token,
+			contentType: "text/plain", body: order})
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("failed to create order %s: status %d", order, resp.StatusCode)
+		}
+	}
+
+	var got []string
+	path := "/api/user/orders?limit=2"
+	for pages := 0; path != ""; pages++ {
+		if pages == len(want) {
+			t.Fatal("pagination does not stop")
+		}
+		resp, b := s.do(t, testRequest{method: "GET", path: path, token: token})
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("want status 200, got %d", resp.StatusCode)
+		}
+		var orders []structs.Order
+		if err := json.Unmarshal(b, &orders); err != nil {
+			t.Fatalf("failed to decode orders: %s", err.Error())
+		}
+		if len(orders) > 2 {
+			t.Fatalf("limit is ignored: got %d orders", len(orders))
+		}
+		for _, o := range orders {
+			got = append(got, o.Number)
+		}
+		path = ""
+		if cursor := resp.Header.Get("X-Next-Cursor"); cursor != "" {
+			path = "/api/user/orders?limit=2&cursor=" + cursor
+		}
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("want orders %v, got %v", want, got)
+	}
+
+	for _, query := range []string{"limit=0", "cursor=bad", "status=LOST", "sort=up", "from=yesterday"} {
+		resp, _ := s.do(t, testRequest{method: "GET", path: "/api/user/orders?" + query, token: token})
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: want status 400, got %d", query, resp.StatusCode)
+		}
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	s := newTestServer(t)
+	code, first := s.auth(t, "/api/user/register", "user", "password")
+	if code != http.StatusOK {
+		t.Fatalf("failed to register user: status %d", code)
+	}
+	refresh := func(token string) (int, structs.TokenResponse) {
+		resp, b := s.do(t, testRequest{method: "POST", path: "/api/user/token/refresh",
+			contentType: "application/json", body: `{"refresh_token": "` + token + `"}`})
+		var tokens structs.TokenResponse
+		if resp.StatusCode == http.StatusOK {
+			if err := json.Unmarshal(b, &tokens); err != nil {
+				t.Fatalf("failed to decode tokens: %s", err.Error())
+			}
+		}
+		return resp.StatusCode, tokens
+	}
+
+	code, second := refresh(first.RefreshToken)
+	if code != http.StatusOK {
+		t.Fatalf("want status 200, got %d", code)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatal("refresh token is not rotated")
+	}
+	resp, _ := s.do(t, testRequest{method: "GET", path: "/", token: second.AccessToken})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("refreshed access token is not accepted: status %d", resp.StatusCode)
+	}
+
+	// reuse of rotated token revokes the whole session
+	if code, _ := refresh(first.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("reused refresh token: want status 401, got %d", code)
+	}
+	if code, _ := refresh(second.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("refresh token of revoked session: want status 401, got %d", code)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	s := newTestServer(t)
+	code, tokens := s.auth(t, "/api/user/register", "user", "password")
+	if code != http.StatusOK {
+		t.Fatalf("failed to register user: status %d", code)
+	}
+
+	resp, _ := s.do(t, testRequest{method: "POST", path: "/api/user/logout", token: tokens.AccessToken})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("want status 200, got %d", resp.StatusCode)
+	}
+	resp, _ = s.do(t, testRequest{method: "GET", path: "/", token: tokens.AccessToken})
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("revoked access token: want status 401, got %d", resp.StatusCode)
+	}
+	resp, _ = s.do(t, testRequest{method: "POST", path: "/api/user/token/refresh",
+		contentType: "application/json", body: `{"refresh_token": "` + tokens.RefreshToken + `"}`})
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("refresh token of revoked session: want status 401, got %d", resp.StatusCode)
+	}
+}
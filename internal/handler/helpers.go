@@ -4,9 +4,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math/rand"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/zklevsha/go-musthave-diploma/internal/archive"
 	"github.com/zklevsha/go-musthave-diploma/internal/jwt"
@@ -30,22 +31,98 @@ func getErrStatusCode(err error) int {
 		return http.StatusUnauthorized
 	case errors.Is(err, structs.ErrOrderIDAlreadyUsed):
 		return http.StatusConflict
+	case errors.Is(err, structs.ErrIdempotencyKeyLost):
+		return http.StatusConflict
+	case errors.Is(err, structs.ErrInsufficientFunds):
+		return http.StatusPaymentRequired
+	case errors.Is(err, structs.ErrOrderNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, structs.ErrOrderStatusFinal):
+		return http.StatusConflict
+	case errors.Is(err, structs.ErrUnknownOrderStatus):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, structs.ErrRefreshTokenInvalid):
+		return http.StatusUnauthorized
+	case errors.Is(err, structs.ErrRefreshTokenReused):
+		return http.StatusUnauthorized
 	default:
 		return http.StatusInternalServerError
 	}
 }
 
-func TokenGetUserID(r *http.Request, key string) (int, error) {
+const maxListLimit = 1000
+
+var orderStatuses = map[string]bool{"NEW": true, "PROCESSING": true, "INVALID": true, "PROCESSED": true}
+
+// getListFilter parses list query parameters:
+// limit, cursor, status (if withStatus), from/to (RFC3339) and sort (asc|desc)
+func getListFilter(r *http.Request, withStatus bool) (structs.ListFilter, error) {
+	var f structs.ListFilter
+	q := r.URL.Query()
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return f, fmt.Errorf("limit must be an integer in range [1, %d]", maxListLimit)
+		}
+		f.Limit = limit
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		c, err := structs.DecodeCursor(v)
+		if err != nil {
+			return f, err
+		}
+		f.After = &c
+	}
+
+	if v := q.Get("status"); v != "" {
+		if !withStatus || !orderStatuses[v] {
+			return f, fmt.Errorf("bad status filter: %s", v)
+		}
+		f.Status = v
+	}
+
+	for param, dst := range map[string]*int64{"from": &f.From, "to": &f.To} {
+		v := q.Get(param)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return f, fmt.Errorf("%s must be RFC3339 date: %s", param, err.Error())
+		}
+		*dst = t.Unix()
+	}
+
+	switch q.Get("sort") {
+	case "", "asc":
+	case "desc":
+		f.Desc = true
+	default:
+		return f, fmt.Errorf("sort must be asc or desc")
+	}
+	return f, nil
+}
+
+// setNextCursor passes cursor of the next page to the client
+func setNextCursor(w http.ResponseWriter, next *structs.Cursor) {
+	if next != nil {
+		w.Header().Set("X-Next-Cursor", next.Encode())
+	}
+}
+
+func TokenGetClaims(r *http.Request, keyring *jwt.Keyring) (jwt.Claims, error) {
 	auth := r.Header.Get("Authorization")
 	if auth == "" {
-		return -1, errors.New("authorization header is not set")
+		return jwt.Claims{}, errors.New("authorization header is not set")
 	}
 	splitToken := strings.Split(auth, "Bearer")
 	if len(splitToken) != 2 {
-		return -1, errors.New("bad format Authorization header: expect Bearer <jwt-token>")
+		return jwt.Claims{}, errors.New("bad format Authorization header: expect Bearer <jwt-token>")
 	}
 	token := strings.TrimSpace(splitToken[1])
-	return jwt.GetUserID(token, key)
+	return jwt.Parse(token, keyring)
 }
 
 func encodeResponse(str interface{}, compress bool) ([]byte, error) {
@@ -82,12 +159,3 @@ func sendResponse(w http.ResponseWriter, r *http.Request, code int,
 	w.WriteHeader(code)
 	w.Write(responseBody)
 }
-
-func tooManyReq(w http.ResponseWriter, r *http.Request, chance int) {
-	if chance < rand.Intn(100) {
-		return
-	}
-	w.Header().Set("Retry-After", "60")
-	sendResponse(w, r, http.StatusTooManyRequests,
-		structs.Response{Error: "No more than N requests per minute allowed"})
-}
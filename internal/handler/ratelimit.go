@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+type rateLimit struct {
+	Limit int `json:"limit"`
+}
+
+// rateLimitMiddleware limits number of requests per minute of each client IP
+func (a *accHandler) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, retryAfter := a.emulator.AllowRequest(clientIP(r))
+		if !ok {
+			tooManyRequests(w, retryAfter, a.emulator.RateLimit())
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tooManyRequests sends 429 response as described in specification
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration, limit int) {
+	n := "N"
+	if limit > 0 {
+		n = strconv.Itoa(limit)
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write([]byte(fmt.Sprintf("No more than %s requests per minute allowed", n)))
+}
+
+// clientIP returns IP address of request`s remote peer
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (a *accHandler) getRateLimitHandler(w http.ResponseWriter, r *http.Request) {
+	sendResponse(w, r, http.StatusOK, rateLimit{Limit: a.emulator.RateLimit()})
+}
+
+func (a *accHandler) setRateLimitHandler(w http.ResponseWriter, r *http.Request) {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		e := fmt.Sprintf("failed to read body: %s", err.Error())
+		sendResponse(w, r, http.StatusBadRequest, structs.Response{Error: e})
+		return
+	}
+	var l rateLimit
+	if err := json.Unmarshal(b, &l); err != nil {
+		e := fmt.Sprintf("failed to parse body: %s", err.Error())
+		sendResponse(w, r, http.StatusBadRequest, structs.Response{Error: e})
+		return
+	}
+	if l.Limit < 0 {
+		sendResponse(w, r, http.StatusBadRequest,
+			structs.Response{Error: "limit must not be negative"})
+		return
+	}
+	a.emulator.SetRateLimit(l.Limit)
+	sendResponse(w, r, http.StatusOK, l)
+}
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/jwt"
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+// newRefreshToken returns random opaque refresh token and its hash (stored instead of token)
+func newRefreshToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate refresh token: %s", err.Error())
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashRefreshToken(token), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sendTokens signs access token and sends it with refresh token
+func (h *Handler) sendTokens(w http.ResponseWriter, r *http.Request, claims jwt.Claims,
+	refreshToken string, message string) {
+	token, err := jwt.Generate(claims, h.keyring)
+	if err != nil {
+		e := fmt.Sprintf("failed to generate jwt token: %s", err.Error())
+		sendResponse(w, r, http.StatusInternalServerError, structs.Response{Error: e})
+		return
+	}
+	w.Header().Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	sendResponse(w, r, http.StatusOK, structs.TokenResponse{
+		Message:      message,
+		AccessToken:  token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(time.Until(claims.ExpiresAt).Seconds()),
+		RefreshToken: refreshToken,
+	})
+}
+
+// issueTokens starts a new session (refresh token family) of user
+func (h *Handler) issueTokens(w http.ResponseWriter, r *http.Request, userid int, message string) {
+	claims, err := jwt.NewClaims(userid, h.accessTTL)
+	if err != nil {
+		sendResponse(w, r, http.StatusInternalServerError, structs.Response{Error: err.Error()})
+		return
+	}
+	family, err := jwt.NewID()
+	if err != nil {
+		sendResponse(w, r, http.StatusInternalServerError, structs.Response{Error: err.Error()})
+		return
+	}
+	refreshToken, hash, err := newRefreshToken()
+	if err != nil {
+		sendResponse(w, r, http.StatusInternalServerError, structs.Response{Error: err.Error()})
+		return
+	}
+
+	err = h.Storage.CreateRefreshToken(structs.RefreshToken{
+		Hash:            hash,
+		UserID:          userid,
+		Family:          family,
+		ExpiresAt:       time.Now().Add(h.refreshTTL),
+		AccessID:        claims.ID,
+		AccessExpiresAt: claims.ExpiresAt,
+	})
+	if err != nil {
+		e := fmt.Sprintf("failed to store refresh token: %s", err.Error())
+		sendResponse(w, r, http.StatusInternalServerError, structs.Response{Error: e})
+		return
+	}
+	h.sendTokens(w, r, claims, refreshToken, message)
+}
+
+func (h *Handler) refreshHandler(w http.ResponseWriter, r *http.Request) {
+	// RequestCtxBody{} should be set in read body middleware
+	body := r.Context().Value(structs.RequestCtxBody{}).([]byte)
+	var req structs.RefreshRequest
+	err := json.NewDecoder(bytes.NewReader(body)).Decode(&req)
+	if err != nil {
+		e := fmt.Sprintf("failed to decode request body: %s", err.Error())
+		sendResponse(w, r, http.StatusBadRequest, structs.Response{Error: e})
+		return
+	}
+	if req.RefreshToken == "" {
+		sendResponse(w, r, http.StatusBadRequest, structs.Response{Error: "refresh_token is not set"})
+		return
+	}
+
+	// user id is known after rotation
+	claims, err := jwt.NewClaims(0, h.accessTTL)
+	if err != nil {
+		sendResponse(w, r, http.StatusInternalServerError, structs.Response{Error: err.Error()})
+		return
+	}
+	refreshToken, hash, err := newRefreshToken()
+	if err != nil {
+		sendResponse(w, r, http.StatusInternalServerError, structs.Response{Error: err.Error()})
+		return
+	}
+
+	userid, err := h.Storage.RotateRefreshToken(hashRefreshToken(req.RefreshToken), structs.RefreshToken{
+		Hash:            hash,
+		ExpiresAt:       time.Now().Add(h.refreshTTL),
+		AccessID:        claims.ID,
+		AccessExpiresAt: claims.ExpiresAt,
+	})
+	if err != nil {
+		e := fmt.Sprintf("failed to refresh token: %s", err.Error())
+		sendResponse(w, r, getErrStatusCode(err), structs.Response{Error: e})
+		return
+	}
+	claims.UserID = userid
+	h.sendTokens(w, r, claims, refreshToken, "")
+}
+
+// jwksHandler publishes public keys of token signing keys
+func (h *Handler) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	// keys are rotated rarely, but removed keys must not be trusted for long
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	sendResponse(w, r, http.StatusOK, h.keyring.JWKS())
+}
+
+func (h *Handler) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	// RequestCtxClaims{} should be set in authentication middleware
+	claims := r.Context().Value(structs.RequestCtxClaims{}).(jwt.Claims)
+	if claims.ID == "" {
+		sendResponse(w, r, http.StatusBadRequest,
+			structs.Response{Error: "legacy token can`t be revoked, login again to get a new one"})
+		return
+	}
+	err := h.Storage.RevokeSession(claims.UserID, claims.ID, claims.ExpiresAt)
+	if err != nil {
+		e := fmt.Sprintf("failed to revoke session: %s", err.Error())
+		sendResponse(w, r, http.StatusInternalServerError, structs.Response{Error: e})
+		return
+	}
+	sendResponse(w, r, http.StatusOK, structs.Response{Message: "logged out"})
+}
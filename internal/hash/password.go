@@ -0,0 +1,49 @@
+package hash
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HashPassword returns bcrypt hash of the password.
+// Salt is generated by bcrypt for every call and stored inside the hash
+func HashPassword(password string, cost int) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return "", err
+	}
+	return string(h), nil
+}
+
+// CheckPassword compares password with the stored hash.
+// Stored hash is either a bcrypt hash or a legacy HMAC signed with server key.
+// needRehash is true if the password is valid but the stored hash is legacy
+// or was created with a different cost
+func CheckPassword(password, hashed, key string, cost int) (ok bool, needRehash bool, err error) {
+	if !isBcrypt(hashed) {
+		ok, err = Verify(password, key, hashed)
+		return ok, ok, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+
+	hashCost, err := bcrypt.Cost([]byte(hashed))
+	if err != nil {
+		return true, false, err
+	}
+	return true, hashCost != cost, nil
+}
+
+func isBcrypt(hashed string) bool {
+	return strings.HasPrefix(hashed, "$2a$") ||
+		strings.HasPrefix(hashed, "$2b$") ||
+		strings.HasPrefix(hashed, "$2y$")
+}
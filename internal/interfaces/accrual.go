@@ -0,0 +1,16 @@
+package interfaces
+
+import (
+	"context"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+type AccrualClient interface {
+	// GetOrder returns order status and accrual from accrual system.
+	// Returns structs.ErrOrderNotRegistered, structs.RateLimitError or
+	// error wrapping structs.ErrAccrualUnavailable
+	GetOrder(ctx context.Context, orderID int) (structs.Order, error)
+	// State returns state of circuit breaker (structs.BreakerClosed, BreakerOpen or BreakerHalfOpen)
+	State() string
+}
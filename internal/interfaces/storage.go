@@ -1,18 +1,70 @@
 package interfaces
 
-import "github.com/zklevsha/go-musthave-diploma/internal/structs"
+import (
+	"context"
+	"time"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/money"
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
 
 type Storage interface {
 	Close()
 	Init() error
 	Register(login string, password string) (int, error)
-	GetUserID(creds structs.Credentials) (int, error)
+	GetUser(login string) (structs.User, error)
+	SetUserPassword(id int, password string) (int64, error)
 	CreateOrder(userid int, orderid int) (bool, error)
-	GetOrders(userid int) ([]structs.Order, error)
-	GetUnprocessedOrders() ([]int, error)
-	SetOrderStatus(id int, status string) (int64, error)
-	SetOrderAccrual(id int, accrual float64) (int64, error)
+	// GetOrders returns page of user`s orders and cursor of the next page (nil if it is the last one)
+	GetOrders(userid int, filter structs.ListFilter) ([]structs.Order, *structs.Cursor, error)
+	// ClaimOrders leases up to limit orders which are due for processing to owner until lease expires.
+	// Orders leased by other owners, dead-lettered orders and orders which status
+	// was changed less than minAge ago are skipped
+	ClaimOrders(owner string, limit int, lease time.Duration, minAge time.Duration) ([]int, error)
+	// ClaimOrder leases order to owner if it is due for processing and is not leased by another owner
+	ClaimOrder(owner string, id int, lease time.Duration) (bool, error)
+	// SubscribeOrders returns channel of new order ids, it is closed when ctx is done.
+	// Notifications may be lost, so subscriber must not rely on them only
+	SubscribeOrders(ctx context.Context) (<-chan int, error)
+	// ReleaseOrders releases all leases of owner
+	ReleaseOrders(owner string) (int64, error)
+	// RetryOrder registers failed processing attempt and schedules the next one.
+	// Returns number of failed attempts and whether order was dead-lettered
+	RetryOrder(id int, lastErr string, backoff structs.Backoff) (int, bool, error)
+	// PostponeOrder schedules the next processing attempt after delay.
+	// Unlike RetryOrder it does not count failed attempt, so order is never dead-lettered
+	PostponeOrder(id int, reason string, delay time.Duration) error
+	GetDeadOrders() ([]structs.DeadOrder, error)
+	// RequeueOrder returns dead-lettered order to processing
+	RequeueOrder(id int) (int64, error)
+	// SetOrderResult changes order status and records the transition in order history.
+	// Accrual of PROCESSED order is credited to the user`s balance in the same transaction
+	SetOrderResult(id int, status string, accrual *money.Amount, source string, payload []byte) (int64, error)
+	GetOrderHistory(userid int, orderid int) ([]structs.OrderEvent, error)
 	GetUserBalance(id int) (structs.Balance, error)
-	Withdraw(userid int, winthdraw structs.Withdraw) error
-	GetWithdrawls(userid int) ([]structs.Withdraw, error)
+	// Withdraw checks user balance and stores withdrawal atomically.
+	// If idempotency is not nil, its response is stored in the same transaction
+	// (structs.ErrIdempotencyKeyLost is returned and nothing is withdrawn if reservation was taken over).
+	// Returns structs.ErrInsufficientFunds if balance is too low
+	Withdraw(userid int, winthdraw structs.Withdraw, idempotency *structs.IdempotencyRecord) error
+	GetWithdrawls(userid int, filter structs.ListFilter) ([]structs.Withdraw, *structs.Cursor, error)
+	// AddLedgerEntry adds manual ADJUSTMENT or REVERSAL of existing entry
+	AddLedgerEntry(userid int, entry structs.LedgerEntry) (structs.LedgerEntry, error)
+	GetLedger(userid int) ([]structs.LedgerEntry, error)
+	// ReserveIdempotencyKey returns reservation (and true) or stored record (and false) if key was already used.
+	// Abandoned reservations (see structs.IdempotencyLockTimeout) and expired records
+	// (see structs.IdempotencyKeyTTL) are replaced
+	ReserveIdempotencyKey(userid int, key string, requestHash string) (structs.IdempotencyRecord, bool, error)
+	// SaveIdempotencyResponse and DeleteIdempotencyKey return structs.ErrIdempotencyKeyLost
+	// if reservation was taken over by another request
+	SaveIdempotencyResponse(userid int, reservation structs.IdempotencyRecord) error
+	DeleteIdempotencyKey(userid int, reservation structs.IdempotencyRecord) error
+	CreateRefreshToken(token structs.RefreshToken) error
+	// RotateRefreshToken marks refresh token as used and stores next one in the same family.
+	// Returns user id of the token. If token was already used, whole family is revoked
+	// and structs.ErrRefreshTokenReused is returned
+	RotateRefreshToken(hash string, next structs.RefreshToken) (int, error)
+	// RevokeSession revokes access token and refresh token family it was issued with
+	RevokeSession(userid int, accessID string, accessExpiresAt time.Time) error
+	IsTokenRevoked(accessID string) (bool, error)
 }
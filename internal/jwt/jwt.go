@@ -1,39 +1,91 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt"
 )
 
-func Generate(userid int, key string) (string, error) {
+// Claims are gophermart access token claims
+type Claims struct {
+	UserID int
+	// ID (jti) identifies token in revocation list, it is empty in legacy tokens
+	ID        string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// NewClaims returns claims of a new access token with random ID
+func NewClaims(userid int, ttl time.Duration) (Claims, error) {
+	id, err := NewID()
+	if err != nil {
+		return Claims{}, err
+	}
 	now := time.Now()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"id":  userid,
-		"iat": now.Unix(),
-		"exp": now.Add(time.Hour * 24).Unix(),
+	return Claims{UserID: userid, ID: id, IssuedAt: now, ExpiresAt: now.Add(ttl)}, nil
+}
+
+// NewID returns random token ID
+func NewID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token id: %s", err.Error())
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Generate signs token with active key of keyring
+func Generate(c Claims, k *Keyring) (string, error) {
+	key := k.signingKey()
+	token := jwt.NewWithClaims(key.method, jwt.MapClaims{
+		"id":  c.UserID,
+		"jti": c.ID,
+		"iat": c.IssuedAt.Unix(),
+		"exp": c.ExpiresAt.Unix(),
 	})
-	tokenString, err := token.SignedString([]byte(key))
+	token.Header["kid"] = key.ID
+	tokenString, err := token.SignedString(key.signKey)
 	return tokenString, err
 }
 
-func GetUserID(tokenString string, key string) (int, error) {
-	// Parse takes the token string and a function for looking up the key. The latter is especially
-	// useful if you use multiple keys for your application.  The standard is to use 'kid' in the
-	// head of the token to identify which key to use, but the parsed token (head and claims) is provided
-	// to the callback, providing flexibility.
+// Parse verifies token with the key of its kid (legacy tokens without kid are verified with legacy key)
+func Parse(tokenString string, k *Keyring) (Claims, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := k.verificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
 		// Don't forget to validate the alg is what you expect:
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		if token.Method.Alg() != key.method.Alg() {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(key), nil
+		return key.verifyKey, nil
 	})
+	if err != nil {
+		return Claims{}, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return Claims{}, errors.New("token is invalid")
+	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		return int(claims["id"].(float64)), nil
-	} else {
-		return -1, err
+	var c Claims
+	id, ok := claims["id"].(float64)
+	if !ok {
+		return Claims{}, errors.New("token has no user id")
+	}
+	c.UserID = int(id)
+	c.ID, _ = claims["jti"].(string)
+	if iat, ok := claims["iat"].(float64); ok {
+		c.IssuedAt = time.Unix(int64(iat), 0)
+	}
+	if exp, ok := claims["exp"].(float64); ok {
+		c.ExpiresAt = time.Unix(int64(exp), 0)
 	}
+	return c, nil
 }
@@ -0,0 +1,240 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// DefaultKeyID is a key id of keyring created from jwt key when keyring file is not set
+const DefaultKeyID = "default"
+
+// Signing algorithms
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+var ErrKeyExpired = errors.New("signing key is expired")
+
+// Key is a token signing key
+type Key struct {
+	ID string `json:"kid"`
+	// Algorithm is HS256 (default), RS256 or EdDSA
+	Algorithm string `json:"alg,omitempty"`
+	// Secret is a key of HS256
+	Secret string `json:"secret,omitempty"`
+	// PrivateKeyFile and PublicKeyFile are paths to PEM files of RS256 and EdDSA keys.
+	// Private key is required for active key only, public key is derived from private one if not set
+	PrivateKeyFile string `json:"private_key_file,omitempty"`
+	PublicKeyFile  string `json:"public_key_file,omitempty"`
+	// ExpiresAt is a time after which tokens signed with the key are rejected (zero - never)
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+func (k Key) expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt)
+}
+
+// init loads key material according to algorithm
+func (k *Key) init() error {
+	if k.ID == "" {
+		return errors.New("key id is not set")
+	}
+	if k.Algorithm == "" {
+		k.Algorithm = AlgHS256
+	}
+
+	switch k.Algorithm {
+	case AlgHS256:
+		if k.Secret == "" {
+			return fmt.Errorf("secret of key %q is not set", k.ID)
+		}
+		k.method = jwt.SigningMethodHS256
+		k.signKey = []byte(k.Secret)
+		k.verifyKey = []byte(k.Secret)
+		return nil
+	case AlgRS256:
+		k.method = jwt.SigningMethodRS256
+	case AlgEdDSA:
+		k.method = jwt.SigningMethodEdDSA
+	default:
+		return fmt.Errorf("unsupported algorithm %q of key %q", k.Algorithm, k.ID)
+	}
+
+	if k.PrivateKeyFile == "" && k.PublicKeyFile == "" {
+		return fmt.Errorf("neither private nor public key file of key %q is set", k.ID)
+	}
+	if k.PrivateKeyFile != "" {
+		pem, err := ioutil.ReadFile(k.PrivateKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read private key of key %q: %s", k.ID, err.Error())
+		}
+		if k.Algorithm == AlgRS256 {
+			key, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+			if err != nil {
+				return fmt.Errorf("failed to parse private key of key %q: %s", k.ID, err.Error())
+			}
+			k.signKey, k.verifyKey = key, &key.PublicKey
+		} else {
+			key, err := jwt.ParseEdPrivateKeyFromPEM(pem)
+			if err != nil {
+				return fmt.Errorf("failed to parse private key of key %q: %s", k.ID, err.Error())
+			}
+			k.signKey, k.verifyKey = key, key.(crypto.Signer).Public()
+		}
+	}
+	if k.PublicKeyFile != "" {
+		pem, err := ioutil.ReadFile(k.PublicKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read public key of key %q: %s", k.ID, err.Error())
+		}
+		var key interface{}
+		if k.Algorithm == AlgRS256 {
+			key, err = jwt.ParseRSAPublicKeyFromPEM(pem)
+		} else {
+			key, err = jwt.ParseEdPublicKeyFromPEM(pem)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse public key of key %q: %s", k.ID, err.Error())
+		}
+		k.verifyKey = key
+	}
+	return nil
+}
+
+// KeyringFile is a content of keyring file:
+//
+//	{"active": "2022-06",
+//	 "keys": [
+//		{"kid": "2022-06", "alg": "EdDSA", "private_key_file": "ed25519.pem"},
+//		{"kid": "2022-03", "alg": "RS256", "public_key_file": "rsa.pub.pem"},
+//		{"kid": "2022-01", "secret": "...", "expires_at": "2022-07-01T00:00:00Z"}]}
+//
+// Relative key file paths are relative to keyring file.
+// To rotate keys add a new key, make it active and set expires_at of the old one
+// to the time when all tokens signed with it are expired
+type KeyringFile struct {
+	Active string `json:"active"`
+	Keys   []Key  `json:"keys"`
+}
+
+// Keyring signs tokens with active key and verifies them with the key of token`s kid
+type Keyring struct {
+	active string
+	keys   map[string]Key
+	// legacy is used to verify tokens without kid
+	legacy []byte
+}
+
+// NewKeyring creates keyring. Tokens without kid are verified with legacy key (if it is not empty)
+func NewKeyring(keys []Key, active string, legacy string) (*Keyring, error) {
+	k := &Keyring{active: active, keys: make(map[string]Key)}
+	if legacy != "" {
+		k.legacy = []byte(legacy)
+	}
+	for _, key := range keys {
+		if err := key.init(); err != nil {
+			return nil, err
+		}
+		if _, ok := k.keys[key.ID]; ok {
+			return nil, fmt.Errorf("key %q is duplicated", key.ID)
+		}
+		k.keys[key.ID] = key
+	}
+	a, ok := k.keys[active]
+	if !ok {
+		return nil, fmt.Errorf("active key %q is not found", active)
+	}
+	if a.signKey == nil {
+		return nil, fmt.Errorf("private key of active key %q is not set", active)
+	}
+	if a.expired(time.Now()) {
+		return nil, fmt.Errorf("active key %q is expired", active)
+	}
+	return k, nil
+}
+
+// LoadKeyring reads keyring file. Active key from file is overridden by active (if it is not empty)
+func LoadKeyring(path string, active string, legacy string) (*Keyring, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring file: %s", err.Error())
+	}
+	var f KeyringFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse keyring file: %s", err.Error())
+	}
+	dir := filepath.Dir(path)
+	for i := range f.Keys {
+		f.Keys[i].PrivateKeyFile = resolvePath(dir, f.Keys[i].PrivateKeyFile)
+		f.Keys[i].PublicKeyFile = resolvePath(dir, f.Keys[i].PublicKeyFile)
+	}
+	if active == "" {
+		active = f.Active
+	}
+	return NewKeyring(f.Keys, active, legacy)
+}
+
+func resolvePath(dir string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// ActiveKey returns id of the key new tokens are signed with
+func (k *Keyring) ActiveKey() string {
+	return k.active
+}
+
+func (k *Keyring) signingKey() Key {
+	return k.keys[k.active]
+}
+
+// verificationKey returns key of id (legacy HS256 key if id is empty)
+func (k *Keyring) verificationKey(id string) (Key, error) {
+	if id == "" {
+		if k.legacy == nil {
+			return Key{}, fmt.Errorf("%w: token has no kid", ErrUnknownKey)
+		}
+		return Key{method: jwt.SigningMethodHS256, verifyKey: k.legacy}, nil
+	}
+	key, ok := k.keys[id]
+	if !ok {
+		return Key{}, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	if key.expired(time.Now()) {
+		return Key{}, fmt.Errorf("%w: %q", ErrKeyExpired, id)
+	}
+	return key, nil
+}
+
+// publicKeys returns non-expired RS256 and EdDSA public keys
+func (k *Keyring) publicKeys() []Key {
+	now := time.Now()
+	var keys []Key
+	for _, key := range k.keys {
+		if key.Algorithm == AlgHS256 || key.expired(now) {
+			continue
+		}
+		switch key.verifyKey.(type) {
+		case *rsa.PublicKey, ed25519.PublicKey:
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,97 @@
+package memstorage
+
+import (
+	"time"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+type idempotencyKey struct {
+	userid int
+	key    string
+}
+
+// ReserveIdempotencyKey stores key of the request which is going to be processed.
+// If key was already used, stored record is returned and reserved is false.
+// Abandoned reservations and expired records are replaced.
+// CreatedAt of reservation identifies it in SaveIdempotencyResponse and DeleteIdempotencyKey
+func (m *MemStorage) ReserveIdempotencyKey(userid int, key string,
+	requestHash string) (structs.IdempotencyRecord, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkInit(); err != nil {
+		return structs.IdempotencyRecord{}, false, err
+	}
+
+	now := time.Now()
+	m.sweepIdempotencyKeys(now)
+
+	k := idempotencyKey{userid: userid, key: key}
+	if rec, ok := m.idempotency[k]; ok && now.Sub(rec.CreatedAt) <= structs.IdempotencyKeyTTL {
+		// reservation of request which has not finished within lock timeout is taken over
+		abandoned := rec.StatusCode == 0 && now.Sub(rec.CreatedAt) > structs.IdempotencyLockTimeout
+		if !abandoned {
+			return *rec, false, nil
+		}
+	}
+	rec := &structs.IdempotencyRecord{Key: key, RequestHash: requestHash, CreatedAt: now}
+	m.idempotency[k] = rec
+	return *rec, true, nil
+}
+
+// sweepIdempotencyKeys removes expired records (at most once a minute).
+// It must be called with m.mu held
+func (m *MemStorage) sweepIdempotencyKeys(now time.Time) {
+	if now.Sub(m.idempotencySwept) < time.Minute {
+		return
+	}
+	m.idempotencySwept = now
+	for k, rec := range m.idempotency {
+		if now.Sub(rec.CreatedAt) > structs.IdempotencyKeyTTL {
+			delete(m.idempotency, k)
+		}
+	}
+}
+
+// SaveIdempotencyResponse stores response of the request with reserved key
+func (m *MemStorage) SaveIdempotencyResponse(userid int, reservation structs.IdempotencyRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkInit(); err != nil {
+		return err
+	}
+
+	rec, err := m.ownedIdempotencyKey(userid, reservation)
+	if err != nil {
+		return err
+	}
+	rec.StatusCode = reservation.StatusCode
+	rec.Response = append([]byte(nil), reservation.Response...)
+	return nil
+}
+
+// ownedIdempotencyKey returns stored reservation if it was not taken over by another request.
+// It must be called with m.mu held
+func (m *MemStorage) ownedIdempotencyKey(userid int,
+	reservation structs.IdempotencyRecord) (*structs.IdempotencyRecord, error) {
+	rec, ok := m.idempotency[idempotencyKey{userid: userid, key: reservation.Key}]
+	if !ok || !rec.CreatedAt.Equal(reservation.CreatedAt) {
+		return nil, structs.ErrIdempotencyKeyLost
+	}
+	return rec, nil
+}
+
+// DeleteIdempotencyKey releases reserved key, so the request can be retried
+func (m *MemStorage) DeleteIdempotencyKey(userid int, reservation structs.IdempotencyRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkInit(); err != nil {
+		return err
+	}
+
+	if _, err := m.ownedIdempotencyKey(userid, reservation); err != nil {
+		return err
+	}
+	delete(m.idempotency, idempotencyKey{userid: userid, key: reservation.Key})
+	return nil
+}
@@ -0,0 +1,68 @@
+package memstorage
+
+import "time"
+
+// ClaimOrders leases up to limit orders which are due for processing to owner until lease expires.
+// Orders which status was changed less than minAge ago are skipped
+func (m *MemStorage) ClaimOrders(owner string, limit int, lease time.Duration, minAge time.Duration) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkInit(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var orders []int
+	for _, id := range m.orderIDs {
+		if len(orders) >= limit {
+			break
+		}
+		o := m.orders[id]
+		if o.status != "NEW" && o.status != "PROCESSING" || o.deadAt != nil ||
+			now.Before(o.nextAttempt) || now.Before(o.leaseUntil) || now.Sub(o.updated) < minAge {
+			continue
+		}
+		o.leaseOwner = owner
+		o.leaseUntil = now.Add(lease)
+		orders = append(orders, id)
+	}
+	return orders, nil
+}
+
+// ClaimOrder leases order to owner if it is due for processing and is not leased by another owner
+func (m *MemStorage) ClaimOrder(owner string, id int, lease time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkInit(); err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	o, ok := m.orders[id]
+	if !ok || o.status != "NEW" && o.status != "PROCESSING" || o.deadAt != nil ||
+		now.Before(o.nextAttempt) || now.Before(o.leaseUntil) {
+		return false, nil
+	}
+	o.leaseOwner = owner
+	o.leaseUntil = now.Add(lease)
+	return true, nil
+}
+
+// ReleaseOrders releases all leases of owner
+func (m *MemStorage) ReleaseOrders(owner string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkInit(); err != nil {
+		return -1, err
+	}
+
+	var count int64
+	for _, o := range m.orders {
+		if o.leaseOwner == owner {
+			o.leaseOwner = ""
+			o.leaseUntil = time.Time{}
+			count++
+		}
+	}
+	return count, nil
+}
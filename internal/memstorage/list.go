@@ -0,0 +1,50 @@
+package memstorage
+
+import (
+	"sort"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+func cursorLess(a, b structs.Cursor) bool {
+	if a.TS != b.TS {
+		return a.TS < b.TS
+	}
+	return a.ID < b.ID
+}
+
+// applyListFilter returns indexes of keys matching date range and cursor,
+// sorted and limited according to the filter, and cursor of the next page
+func applyListFilter(keys []structs.Cursor, f structs.ListFilter) ([]int, *structs.Cursor) {
+	var idx []int
+	for i, k := range keys {
+		if f.From != 0 && k.TS < f.From {
+			continue
+		}
+		if f.To != 0 && k.TS >= f.To {
+			continue
+		}
+		if f.After != nil {
+			if !f.Desc && !cursorLess(*f.After, k) {
+				continue
+			}
+			if f.Desc && !cursorLess(k, *f.After) {
+				continue
+			}
+		}
+		idx = append(idx, i)
+	}
+
+	sort.Slice(idx, func(i, j int) bool {
+		if f.Desc {
+			return cursorLess(keys[idx[j]], keys[idx[i]])
+		}
+		return cursorLess(keys[idx[i]], keys[idx[j]])
+	})
+
+	if f.Limit > 0 && len(idx) > f.Limit {
+		next := keys[idx[f.Limit-1]]
+		return idx[:f.Limit], &next
+	}
+	return idx, nil
+}
@@ -0,0 +1,454 @@
+package memstorage
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/money"
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+type user struct {
+	id       int
+	login    string
+	password string
+}
+
+type order struct {
+	id        int
+	userid    int
+	status    string
+	accrual   *money.Amount
+	createdTS int64
+	// updated is a time of the last status change
+	updated time.Time
+	events  []structs.OrderEvent
+	// retries
+	attempts    int
+	nextAttempt time.Time
+	lastError   string
+	deadAt      *time.Time
+	// lease
+	leaseOwner string
+	leaseUntil time.Time
+}
+
+type ledgerEntry struct {
+	structs.LedgerEntry
+	userid    int
+	createdTS int64
+}
+
+// MemStorage keeps all data in memory.
+// It has the same semantics as db.DBConnector and is used when database is not configured
+type MemStorage struct {
+	mu          sync.RWMutex
+	users       map[int]*user
+	logins      map[string]int
+	orders      map[int]*order
+	orderIDs    []int
+	ledger      []ledgerEntry
+	balances    map[int]*structs.Balance
+	idempotency map[idempotencyKey]*structs.IdempotencyRecord
+	// idempotencySwept is a time of the last removal of expired idempotency records
+	idempotencySwept time.Time
+	subscribers      map[chan int]struct{}
+	// refresh tokens by hash and revoked access tokens with their expiration time
+	refreshTokens map[string]*refreshToken
+	revokedTokens map[string]time.Time
+	lastUserID    int
+	initalized    bool
+}
+
+func (m *MemStorage) checkInit() error {
+	if !m.initalized {
+		return fmt.Errorf("MemStorage is not initiliazed (run MemStorage.Init() to initilize)")
+	}
+	return nil
+}
+
+func (m *MemStorage) Init() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.initalized {
+		return nil
+	}
+	m.users = make(map[int]*user)
+	m.logins = make(map[string]int)
+	m.orders = make(map[int]*order)
+	m.balances = make(map[int]*structs.Balance)
+	m.idempotency = make(map[idempotencyKey]*structs.IdempotencyRecord)
+	m.subscribers = make(map[chan int]struct{})
+	m.refreshTokens = make(map[string]*refreshToken)
+	m.revokedTokens = make(map[string]time.Time)
+	m.initalized = true
+	return nil
+}
+
+func (m *MemStorage) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.initalized = false
+}
+
+func (m *MemStorage) Register(login string, password string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkInit(); err != nil {
+		return -1, err
+	}
+
+	if _, ok := m.logins[login]; ok {
+		return -1, structs.ErrUserAlreadyExists
+	}
+	m.lastUserID++
+	u := &user{id: m.lastUserID, login: login, password: password}
+	m.users[u.id] = u
+	m.logins[login] = u.id
+	m.balances[u.id] = &structs.Balance{}
+	return u.id, nil
+}
+
+func (m *MemStorage) GetUser(login string) (structs.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.checkInit(); err != nil {
+		return structs.User{}, err
+	}
+
+	id, ok := m.logins[login]
+	if !ok {
+		return structs.User{}, structs.ErrUserAuth
+	}
+	u := m.users[id]
+	return structs.User{ID: u.id, Login: u.login, Password: u.password}, nil
+}
+
+func (m *MemStorage) SetUserPassword(id int, password string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkInit(); err != nil {
+		return -1, err
+	}
+
+	u, ok := m.users[id]
+	if !ok {
+		return 0, nil
+	}
+	u.password = password
+	return 1, nil
+}
+
+func (m *MemStorage) CreateOrder(userid int, orderid int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkInit(); err != nil {
+		return false, err
+	}
+
+	if o, ok := m.orders[orderid]; ok {
+		// user already sent this order
+		if o.userid == userid {
+			return false, nil
+		}
+		// orderid was used by different user
+		return false, structs.ErrOrderIDAlreadyUsed
+	}
+	if _, ok := m.users[userid]; !ok {
+		return false, fmt.Errorf("user %d does not exist", userid)
+	}
+
+	now := time.Now()
+	m.orders[orderid] = &order{
+		id:        orderid,
+		userid:    userid,
+		status:    "NEW",
+		createdTS: now.Unix(),
+		updated:   now,
+		events: []structs.OrderEvent{{To: "NEW", Source: structs.SourceUser,
+			CreatedAt: now.Format("2006-01-02T15:04:05-07:00")}},
+	}
+	m.orderIDs = append(m.orderIDs, orderid)
+	m.notify(orderid)
+	return true, nil
+}
+
+func (m *MemStorage) GetOrders(userid int, filter structs.ListFilter) ([]structs.Order, *structs.Cursor, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.checkInit(); err != nil {
+		return nil, nil, err
+	}
+
+	var found []*order
+	var keys []structs.Cursor
+	for _, id := range m.orderIDs {
+		o := m.orders[id]
+		if o.userid != userid || (filter.Status != "" && o.status != filter.Status) {
+			continue
+		}
+		found = append(found, o)
+		keys = append(keys, structs.Cursor{TS: o.createdTS, ID: int64(o.id)})
+	}
+
+	idx, next := applyListFilter(keys, filter)
+	var orders []structs.Order
+	for _, i := range idx {
+		o := found[i]
+		orders = append(orders, structs.Order{
+			Number:     fmt.Sprint(o.id),
+			Status:     o.status,
+			Accrual:    copyAmount(o.accrual),
+			UploadedAt: time.Unix(o.createdTS, 0).Format("2006-01-02T15:04:05-07:00")})
+	}
+	return orders, next, nil
+}
+
+// SetOrderResult changes order status, records the transition in order history and,
+// if order is PROCESSED, sets its accrual and credits it to the user`s balance.
+// Setting the same status again does nothing. Final statuses (INVALID, PROCESSED)
+// can not be changed (structs.ErrOrderStatusFinal)
+func (m *MemStorage) SetOrderResult(id int, status string, accrual *money.Amount,
+	source string, payload []byte) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkInit(); err != nil {
+		return -1, err
+	}
+
+	o, ok := m.orders[id]
+	if !ok {
+		return 0, nil
+	}
+	if o.status == status {
+		return 1, nil
+	}
+	if structs.IsFinalStatus(o.status) {
+		return -1, fmt.Errorf("%w (%s -> %s)", structs.ErrOrderStatusFinal, o.status, status)
+	}
+	o.events = append(o.events, structs.OrderEvent{
+		From:      o.status,
+		To:        status,
+		Source:    source,
+		Payload:   structs.EventPayload(append([]byte(nil), payload...)),
+		CreatedAt: time.Now().Format("2006-01-02T15:04:05-07:00")})
+	o.status = status
+	o.updated = time.Now()
+	// status change is a progress, so failed attempts are forgotten
+	o.attempts = 0
+	o.nextAttempt = time.Time{}
+	o.lastError = ""
+	// order becomes PROCESSED only once, so accrual is credited only once
+	if status == "PROCESSED" && accrual != nil {
+		o.accrual = copyAmount(accrual)
+		m.appendLedger(o.userid,
+			structs.LedgerEntry{Type: structs.LedgerAccrual, Amount: *accrual, Reference: fmt.Sprint(id)}, 0)
+	}
+	return 1, nil
+}
+
+// GetOrderHistory returns status transitions of the user`s order.
+// Returns structs.ErrOrderNotFound if order does not exist or belongs to another user
+func (m *MemStorage) GetOrderHistory(userid int, orderid int) ([]structs.OrderEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.checkInit(); err != nil {
+		return nil, err
+	}
+
+	o, ok := m.orders[orderid]
+	if !ok || o.userid != userid {
+		return nil, structs.ErrOrderNotFound
+	}
+	return append([]structs.OrderEvent(nil), o.events...), nil
+}
+
+func (m *MemStorage) GetUserBalance(id int) (structs.Balance, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.checkInit(); err != nil {
+		return structs.Balance{}, err
+	}
+	b, ok := m.balances[id]
+	if !ok {
+		return structs.Balance{}, nil
+	}
+	return *b, nil
+}
+
+// appendLedger adds ledger entry and updates user`s balance.
+// It must be called with m.mu held
+func (m *MemStorage) appendLedger(userid int, entry structs.LedgerEntry, withdrawn money.Amount) int64 {
+	entry.ID = int64(len(m.ledger) + 1)
+	m.ledger = append(m.ledger, ledgerEntry{LedgerEntry: entry, userid: userid, createdTS: time.Now().Unix()})
+	b, ok := m.balances[userid]
+	if !ok {
+		b = &structs.Balance{}
+		m.balances[userid] = b
+	}
+	b.Current += entry.Amount
+	b.Withdrawn += withdrawn
+	return entry.ID
+}
+
+// isReversed must be called with m.mu held
+func (m *MemStorage) isReversed(id int64) bool {
+	ref := fmt.Sprint(id)
+	for _, e := range m.ledger {
+		if e.Type == structs.LedgerReversal && e.Reference == ref {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemStorage) Withdraw(userid int, winthdraw structs.Withdraw,
+	idempotency *structs.IdempotencyRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkInit(); err != nil {
+		return err
+	}
+
+	// response is stored with withdrawal, so a retry never withdraws twice
+	var rec *structs.IdempotencyRecord
+	if idempotency != nil {
+		var err error
+		rec, err = m.ownedIdempotencyKey(userid, *idempotency)
+		if err != nil {
+			return err
+		}
+	}
+
+	var current money.Amount
+	if b, ok := m.balances[userid]; ok {
+		current = b.Current
+	}
+	if current < winthdraw.Sum {
+		return fmt.Errorf("%w: winthdraw amount exceeds current balance (%s)",
+			structs.ErrInsufficientFunds, current)
+	}
+	m.appendLedger(userid, structs.LedgerEntry{Type: structs.LedgerWithdrawal, Amount: -winthdraw.Sum,
+		Reference: winthdraw.Order}, winthdraw.Sum)
+	if rec != nil {
+		rec.StatusCode = idempotency.StatusCode
+		rec.Response = append([]byte(nil), idempotency.Response...)
+	}
+	return nil
+}
+
+func (m *MemStorage) GetWithdrawls(userid int, filter structs.ListFilter) ([]structs.Withdraw, *structs.Cursor, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.checkInit(); err != nil {
+		return nil, nil, err
+	}
+
+	// reversed entries are collected in one pass, so listing is linear
+	reversed := make(map[string]bool)
+	for _, e := range m.ledger {
+		if e.userid == userid && e.Type == structs.LedgerReversal {
+			reversed[e.Reference] = true
+		}
+	}
+
+	var found []ledgerEntry
+	var keys []structs.Cursor
+	for _, e := range m.ledger {
+		if e.userid != userid || e.Type != structs.LedgerWithdrawal || reversed[fmt.Sprint(e.ID)] {
+			continue
+		}
+		found = append(found, e)
+		keys = append(keys, structs.Cursor{TS: e.createdTS, ID: e.ID})
+	}
+
+	idx, next := applyListFilter(keys, filter)
+	var withdrawals []structs.Withdraw
+	for _, i := range idx {
+		e := found[i]
+		withdrawals = append(withdrawals, structs.Withdraw{
+			Order:       e.Reference,
+			Sum:         -e.Amount,
+			ProcessedAt: time.Unix(e.createdTS, 0).Format("2006-01-02T15:04:05-07:00")})
+	}
+	return withdrawals, next, nil
+}
+
+// AddLedgerEntry adds manual ADJUSTMENT or REVERSAL of existing entry.
+// Reference of REVERSAL is id of reversed entry, its amount is calculated from reversed entry
+func (m *MemStorage) AddLedgerEntry(userid int, entry structs.LedgerEntry) (structs.LedgerEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkInit(); err != nil {
+		return structs.LedgerEntry{}, err
+	}
+
+	var withdrawn money.Amount
+	switch entry.Type {
+	case structs.LedgerAdjustment:
+		if entry.Reference == "" {
+			return structs.LedgerEntry{}, fmt.Errorf("adjustment reason is not set")
+		}
+	case structs.LedgerReversal:
+		reversedID, err := strconv.ParseInt(entry.Reference, 10, 64)
+		if err != nil {
+			return structs.LedgerEntry{}, fmt.Errorf("bad reversed entry id %s: %s", entry.Reference, err.Error())
+		}
+		if reversedID < 1 || reversedID > int64(len(m.ledger)) || m.ledger[reversedID-1].userid != userid {
+			return structs.LedgerEntry{}, structs.ErrLedgerEntryNotFound
+		}
+		reversed := m.ledger[reversedID-1]
+		if reversed.Type == structs.LedgerReversal {
+			return structs.LedgerEntry{}, fmt.Errorf("reversal entry can not be reversed")
+		}
+		if m.isReversed(reversedID) {
+			return structs.LedgerEntry{}, structs.ErrLedgerEntryReversed
+		}
+		entry.Amount = -reversed.Amount
+		if reversed.Type == structs.LedgerWithdrawal {
+			withdrawn = reversed.Amount
+		}
+	default:
+		return structs.LedgerEntry{}, fmt.Errorf("ledger entry of type %s can not be added manually", entry.Type)
+	}
+
+	var current money.Amount
+	if b, ok := m.balances[userid]; ok {
+		current = b.Current
+	}
+	if current+entry.Amount < 0 {
+		return structs.LedgerEntry{}, fmt.Errorf("%w: entry makes balance negative (%s)",
+			structs.ErrInsufficientFunds, current+entry.Amount)
+	}
+	entry.ID = m.appendLedger(userid, entry, withdrawn)
+	return entry, nil
+}
+
+func (m *MemStorage) GetLedger(userid int) ([]structs.LedgerEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.checkInit(); err != nil {
+		return nil, err
+	}
+
+	var entries []structs.LedgerEntry
+	for _, e := range m.ledger {
+		if e.userid != userid {
+			continue
+		}
+		entry := e.LedgerEntry
+		entry.CreatedAt = time.Unix(e.createdTS, 0).Format("2006-01-02T15:04:05-07:00")
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func copyAmount(f *money.Amount) *money.Amount {
+	if f == nil {
+		return nil
+	}
+	c := *f
+	return &c
+}
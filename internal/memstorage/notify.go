@@ -0,0 +1,38 @@
+package memstorage
+
+import "context"
+
+// notificationsBuffer is a number of notifications kept while subscriber is busy,
+// the rest is dropped
+const notificationsBuffer = 100
+
+// SubscribeOrders returns channel of new order ids.
+// Channel is closed when ctx is done
+func (m *MemStorage) SubscribeOrders(ctx context.Context) (<-chan int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkInit(); err != nil {
+		return nil, err
+	}
+
+	ch := make(chan int, notificationsBuffer)
+	m.subscribers[ch] = struct{}{}
+	go func() {
+		<-ctx.Done()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.subscribers, ch)
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// notify sends order id to subscribers without blocking. m.mu must be locked
+func (m *MemStorage) notify(orderid int) {
+	for ch := range m.subscribers {
+		select {
+		case ch <- orderid:
+		default:
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package memstorage
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+// RetryOrder registers failed processing attempt and schedules the next one according to backoff.
+// Order is dead-lettered when backoff.MaxAttempts is reached
+func (m *MemStorage) RetryOrder(id int, lastErr string, backoff structs.Backoff) (int, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkInit(); err != nil {
+		return -1, false, err
+	}
+
+	o, ok := m.orders[id]
+	if !ok {
+		return -1, false, structs.ErrOrderNotFound
+	}
+	now := time.Now()
+	o.attempts++
+	o.nextAttempt = now.Add(backoff.Delay(o.attempts))
+	o.lastError = lastErr
+	dead := backoff.Dead(o.attempts)
+	if dead {
+		o.deadAt = &now
+	}
+	return o.attempts, dead, nil
+}
+
+// PostponeOrder schedules the next processing attempt after delay without counting failed attempt
+func (m *MemStorage) PostponeOrder(id int, reason string, delay time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkInit(); err != nil {
+		return err
+	}
+
+	o, ok := m.orders[id]
+	if !ok {
+		return structs.ErrOrderNotFound
+	}
+	o.nextAttempt = time.Now().Add(delay)
+	o.lastError = reason
+	return nil
+}
+
+// GetDeadOrders returns dead-lettered orders
+func (m *MemStorage) GetDeadOrders() ([]structs.DeadOrder, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.checkInit(); err != nil {
+		return nil, err
+	}
+
+	var dead []*order
+	for _, id := range m.orderIDs {
+		if o := m.orders[id]; o.deadAt != nil {
+			dead = append(dead, o)
+		}
+	}
+	sort.SliceStable(dead, func(i, j int) bool {
+		return dead[i].deadAt.Before(*dead[j].deadAt)
+	})
+
+	var orders []structs.DeadOrder
+	for _, o := range dead {
+		orders = append(orders, structs.DeadOrder{
+			Number:    strconv.Itoa(o.id),
+			Status:    o.status,
+			Attempts:  o.attempts,
+			LastError: o.lastError,
+			DeadAt:    o.deadAt.Format("2006-01-02T15:04:05-07:00")})
+	}
+	return orders, nil
+}
+
+// RequeueOrder returns dead-lettered order to processing and records it in order history.
+// Returns 0 if order does not exist or is not dead-lettered
+func (m *MemStorage) RequeueOrder(id int) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkInit(); err != nil {
+		return -1, err
+	}
+
+	o, ok := m.orders[id]
+	if !ok || o.deadAt == nil {
+		return 0, nil
+	}
+	o.attempts = 0
+	o.nextAttempt = time.Time{}
+	o.lastError = ""
+	o.deadAt = nil
+	o.events = append(o.events, structs.OrderEvent{
+		From:      o.status,
+		To:        o.status,
+		Source:    structs.SourceAdmin,
+		Payload:   structs.RequeuePayload,
+		CreatedAt: time.Now().Format("2006-01-02T15:04:05-07:00")})
+	return 1, nil
+}
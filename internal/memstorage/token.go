@@ -0,0 +1,108 @@
+package memstorage
+
+import (
+	"time"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+type refreshToken struct {
+	structs.RefreshToken
+	used    bool
+	revoked bool
+}
+
+// CreateRefreshToken stores refresh token of a new family (login).
+// Expired tokens are removed at the same time
+func (m *MemStorage) CreateRefreshToken(t structs.RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkInit(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for hash, rt := range m.refreshTokens {
+		if rt.ExpiresAt.Before(now) {
+			delete(m.refreshTokens, hash)
+		}
+	}
+	m.refreshTokens[t.Hash] = &refreshToken{RefreshToken: t}
+	return nil
+}
+
+// RotateRefreshToken marks refresh token as used and stores next one in the same family.
+// If token was already used, whole family is revoked and structs.ErrRefreshTokenReused is returned
+func (m *MemStorage) RotateRefreshToken(hash string, next structs.RefreshToken) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkInit(); err != nil {
+		return -1, err
+	}
+
+	now := time.Now()
+	rt, ok := m.refreshTokens[hash]
+	if !ok || rt.revoked || rt.ExpiresAt.Before(now) {
+		return -1, structs.ErrRefreshTokenInvalid
+	}
+	if rt.used {
+		// token was stolen or leaked: neither thief nor user can continue the session
+		m.revokeFamily(rt.Family, now)
+		return -1, structs.ErrRefreshTokenReused
+	}
+
+	rt.used = true
+	next.UserID = rt.UserID
+	next.Family = rt.Family
+	m.refreshTokens[next.Hash] = &refreshToken{RefreshToken: next}
+	return rt.UserID, nil
+}
+
+// RevokeSession revokes access token and refresh token family it was issued with
+func (m *MemStorage) RevokeSession(userid int, accessID string, accessExpiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkInit(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	m.revokedTokens[accessID] = accessExpiresAt
+	for _, rt := range m.refreshTokens {
+		if rt.AccessID == accessID && rt.UserID == userid {
+			m.revokeFamily(rt.Family, now)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *MemStorage) IsTokenRevoked(accessID string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.checkInit(); err != nil {
+		return false, err
+	}
+
+	_, ok := m.revokedTokens[accessID]
+	return ok, nil
+}
+
+// revokeFamily revokes all refresh tokens of the family and access tokens issued with them.
+// Expired revoked access tokens are removed at the same time. m.mu must be locked
+func (m *MemStorage) revokeFamily(family string, now time.Time) {
+	for _, rt := range m.refreshTokens {
+		if rt.Family != family {
+			continue
+		}
+		rt.revoked = true
+		if !rt.AccessExpiresAt.Before(now) {
+			m.revokedTokens[rt.AccessID] = rt.AccessExpiresAt
+		}
+	}
+	for jti, exp := range m.revokedTokens {
+		if exp.Before(now) {
+			delete(m.revokedTokens, jti)
+		}
+	}
+}
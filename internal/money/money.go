@@ -0,0 +1,113 @@
+// Package money implements exact arithmetic for loyalty points.
+//
+// Amounts are kept in minor units (1/100 of a point) as int64.
+// Values with more than two decimal places (e.g. accruals received from
+// accrual system) are rounded half away from zero: 0.005 -> 0.01, -0.005 -> -0.01
+package money
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Amount is a number of points in minor units
+type Amount int64
+
+// Scale is a number of minor units in one point
+const Scale = 100
+
+var ErrBadAmount = errors.New("bad amount")
+
+// Parse converts decimal string (like "729.98" or "1e3") to Amount
+func Parse(s string) (Amount, error) {
+	if s == "" || strings.ContainsAny(s, "/") {
+		return 0, fmt.Errorf("%w: %q", ErrBadAmount, s)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrBadAmount, s)
+	}
+	r.Mul(r, big.NewRat(Scale, 1))
+
+	// rounding half away from zero
+	num := new(big.Int).Abs(r.Num())
+	den := r.Denom()
+	q, m := new(big.Int).QuoRem(num, den, new(big.Int))
+	if m.Mul(m, big.NewInt(2)).Cmp(den) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if r.Sign() < 0 {
+		q.Neg(q)
+	}
+	if !q.IsInt64() {
+		return 0, fmt.Errorf("%w: %q is out of range", ErrBadAmount, s)
+	}
+	return Amount(q.Int64()), nil
+}
+
+// FromMinor creates Amount from number of minor units
+func FromMinor(minor int64) Amount {
+	return Amount(minor)
+}
+
+// Minor returns number of minor units
+func (a Amount) Minor() int64 {
+	return int64(a)
+}
+
+// Percent returns p percent of a (rounded half away from zero)
+func (a Amount) Percent(p Amount) Amount {
+	num := new(big.Int).Mul(big.NewInt(int64(a)), big.NewInt(int64(p)))
+	den := big.NewInt(100 * Scale)
+	neg := num.Sign() < 0
+	q, m := new(big.Int).QuoRem(num.Abs(num), den, new(big.Int))
+	if m.Mul(m, big.NewInt(2)).Cmp(den) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if neg {
+		q.Neg(q)
+	}
+	return Amount(q.Int64())
+}
+
+// String returns decimal representation without trailing zeros (e.g. "500.5", "42")
+func (a Amount) String() string {
+	sign := ""
+	v := int64(a)
+	if v < 0 {
+		sign = "-"
+	}
+	// uint64 handles math.MinInt64 properly
+	u := uint64(v)
+	if v < 0 {
+		u = -u
+	}
+	units, frac := u/Scale, u%Scale
+	if frac == 0 {
+		return sign + strconv.FormatUint(units, 10)
+	}
+	fracStr := strings.TrimRight(fmt.Sprintf("%02d", frac), "0")
+	return fmt.Sprintf("%s%d.%s", sign, units, fracStr)
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON accepts both JSON numbers and strings
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	s := string(bytes.Trim(data, `"`))
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
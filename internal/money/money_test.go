@@ -0,0 +1,158 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    Amount
+		wantErr bool
+	}{
+		{"integer", "42", 4200, false},
+		{"decimal", "729.98", 72998, false},
+		{"one decimal place", "500.5", 50050, false},
+		{"negative", "-729.98", -72998, false},
+		{"half rounds up", "0.005", 1, false},
+		{"half rounds away from zero", "-0.005", -1, false},
+		{"below half rounds down", "0.0049", 0, false},
+		{"above half rounds up", "1.2351", 124, false},
+		{"negative below half", "-1.234", -123, false},
+		{"exponent", "1e3", 100000, false},
+		{"negative exponent", "125e-2", 125, false},
+		{"max", "92233720368547758.07", math.MaxInt64, false},
+		{"min", "-92233720368547758.08", math.MinInt64, false},
+		{"above max", "92233720368547758.08", 0, true},
+		{"below min", "-92233720368547758.09", 0, true},
+		{"rounded above max", "92233720368547758.075", 0, true},
+		{"empty", "", 0, true},
+		{"fraction", "1/3", 0, true},
+		{"not a number", "abc", 0, true},
+		{"spaces", " 1", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.s)
+			if tt.wantErr {
+				if !errors.Is(err, ErrBadAmount) {
+					t.Errorf("want ErrBadAmount, got %v (%d)", err, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if got != tt.want {
+				t.Errorf("want %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPercent(t *testing.T) {
+	tests := []struct {
+		name string
+		a    Amount
+		p    Amount
+		want Amount
+	}{
+		{"whole", 10000, 1000, 1000},
+		{"zero", 10000, 0, 0},
+		{"fractional percent", 10000, 250, 250},
+		{"half rounds up", 50, 1000, 5},
+		{"half of minor unit rounds up", 5, 1000, 1},
+		{"below half rounds down", 4, 1000, 0},
+		{"negative half rounds away from zero", -5, 1000, -1},
+		{"negative percent", 5, -1000, -1},
+		{"negative below half", -4, 1000, 0},
+		{"hundred percent", 72998, 10000, 72998},
+		{"large amount", math.MaxInt64, 5000, 4611686018427387904},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.Percent(tt.p); got != tt.want {
+				t.Errorf("%d percent of %d: want %d, got %d", tt.p, tt.a, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		a    Amount
+		want string
+	}{
+		{0, "0"},
+		{4200, "42"},
+		{50050, "500.5"},
+		{72998, "729.98"},
+		{1, "0.01"},
+		{-1, "-0.01"},
+		{-50050, "-500.5"},
+		{math.MaxInt64, "92233720368547758.07"},
+		{math.MinInt64, "-92233720368547758.08"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.a.String(); got != tt.want {
+				t.Errorf("want %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    Amount
+		wantErr bool
+	}{
+		{"number", `729.98`, 72998, false},
+		{"string", `"729.98"`, 72998, false},
+		{"exponent", `1e3`, 100000, false},
+		{"string exponent", `"1.5e1"`, 1500, false},
+		{"negative", `-0.005`, -1, false},
+		{"null", `null`, 0, false},
+		{"out of range", `1e20`, 0, true},
+		{"bad string", `"abc"`, 0, true},
+		{"empty string", `""`, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got struct {
+				Accrual Amount `json:"accrual"`
+			}
+			err := json.Unmarshal([]byte(`{"accrual": `+tt.data+`}`), &got)
+			if tt.wantErr {
+				if !errors.Is(err, ErrBadAmount) {
+					t.Errorf("want ErrBadAmount, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if got.Accrual != tt.want {
+				t.Errorf("want %d, got %d", tt.want, got.Accrual)
+			}
+		})
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	b, err := json.Marshal(struct {
+		Current Amount `json:"current"`
+	}{50050})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if string(b) != `{"current":500.5}` {
+		t.Errorf("unexpected json: %s", b)
+	}
+}
@@ -0,0 +1,54 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/interfaces"
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+// ApplyOrder updates order status and accrual using order received from accrual system.
+// It is idempotent: applying the same order again does nothing.
+// Returns structs.ErrOrderNotFound if order does not exist
+func ApplyOrder(s interfaces.Storage, id int, order structs.Order, source string) error {
+	log.Printf("INFO processor received order status from accrual: %s", order.Status)
+	status, err := orderStatus(order.Status)
+	if err != nil {
+		return err
+	}
+	if status == "PROCESSED" && order.Accrual != nil {
+		log.Printf("INFO proccessor updating order status (-> %s) and accural value (%s)",
+			status, *order.Accrual)
+	} else {
+		log.Printf("INFO proccessor updating order status (-> %s)", status)
+	}
+	// status and accrual are stored in one transaction: PROCESSED order is never polled again,
+	// so accrual stored separately could be lost
+	rowsAffected, err := s.SetOrderResult(id, status, order.Accrual, source, order.Raw)
+	if err != nil {
+		return fmt.Errorf("failed to update order status: %w", err)
+	}
+	if rowsAffected == 0 {
+		return structs.ErrOrderNotFound
+	}
+	if rowsAffected != 1 {
+		e := fmt.Sprintf("ERROR processor failed to update order status: "+
+			"invalid number of affected rows: %d", rowsAffected)
+		return errors.New(e)
+	}
+	return nil
+}
+
+// orderStatus maps accrual system order status to gophermart order status
+func orderStatus(accrualStatus string) (string, error) {
+	switch accrualStatus {
+	case "REGISTERED", "PROCESSING":
+		return "PROCESSING", nil
+	case "INVALID", "PROCESSED":
+		return accrualStatus, nil
+	default:
+		return "", fmt.Errorf("%w %q", structs.ErrUnknownOrderStatus, accrualStatus)
+	}
+}
@@ -0,0 +1,25 @@
+package processor
+
+// Process, ProcessOrders and Paused expose processor internals to tests
+
+func (p *Processor) Process(orders []int) {
+	p.prepare()
+	p.process(orders)
+}
+
+func (p *Processor) ProcessOrders() {
+	p.prepare()
+	p.processOrders()
+}
+
+func (p *Processor) Paused() bool {
+	p.prepare()
+	return p.paused()
+}
+
+func (p *Processor) prepare() {
+	if p.limiter == nil {
+		p.rate = p.RateLimit
+		p.limiter = newLimiter(p.rate)
+	}
+}
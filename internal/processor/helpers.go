@@ -1,8 +0,0 @@
-package processor
-
-import "math"
-
-func roundFloat(val float64, precision uint) float64 {
-	ratio := math.Pow(10, float64(precision))
-	return math.Round(val*ratio) / ratio
-}
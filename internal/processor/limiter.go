@@ -0,0 +1,87 @@
+package processor
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// limiter spreads requests to accrual system evenly.
+// It is shared by all workers of the processor
+type limiter struct {
+	mu          sync.Mutex
+	interval    time.Duration
+	next        time.Time
+	pausedUntil time.Time
+}
+
+// newLimiter creates limiter allowing perMinute requests per minute (0 - no limit)
+func newLimiter(perMinute int) *limiter {
+	l := &limiter{}
+	l.SetRate(perMinute)
+	return l
+}
+
+func (l *limiter) SetRate(perMinute int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if perMinute <= 0 {
+		l.interval = 0
+		return
+	}
+	l.interval = time.Minute / time.Duration(perMinute)
+}
+
+// PauseUntil blocks all requests until t
+func (l *limiter) PauseUntil(t time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if t.After(l.pausedUntil) {
+		l.pausedUntil = t
+	}
+}
+
+// PausedUntil returns time until which requests are blocked
+func (l *limiter) PausedUntil() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.pausedUntil
+}
+
+// reserve returns time slot for the next request
+func (l *limiter) reserve() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	slot := time.Now()
+	if slot.Before(l.next) {
+		slot = l.next
+	}
+	if slot.Before(l.pausedUntil) {
+		slot = l.pausedUntil
+	}
+	l.next = slot.Add(l.interval)
+	return slot
+}
+
+// Wait blocks until the next request is allowed or ctx is done
+func (l *limiter) Wait(ctx context.Context) error {
+	for {
+		delay := time.Until(l.reserve())
+		if delay > 0 {
+			t := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			case <-t.C:
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// limiter could be paused while we were waiting
+		if !time.Now().Before(l.PausedUntil()) {
+			return nil
+		}
+	}
+}
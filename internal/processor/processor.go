@@ -2,11 +2,8 @@ package processor
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
-	"fmt"
 	"log"
-	"net/http"
 	"sync"
 	"time"
 
@@ -14,115 +11,282 @@ import (
 	"github.com/zklevsha/go-musthave-diploma/internal/structs"
 )
 
+// ordersPerWorker is a number of orders claimed per worker on each tick
+const ordersPerWorker = 25
+
+// leaseFactor is a lease duration of claimed orders in ticks
+const leaseFactor = 2
+
 type Processor struct {
 	Delay   time.Duration
 	Ctx     context.Context
 	Wg      *sync.WaitGroup
 	Storage interfaces.Storage
-	Accrual string
+	Accrual interfaces.AccrualClient
+	// Workers is a number of orders processed concurrently
+	Workers int
+	// RateLimit is a maximum number of requests per minute to accrual system (0 - no limit)
+	RateLimit int
+	// MaxAttempts is a number of failed attempts after which order is dead-lettered (0 - unlimited)
+	MaxAttempts int
+	// MaxBackoff is a maximum delay between order processing attempts
+	MaxBackoff time.Duration
+	// RequestTimeout is a timeout of request to accrual system, request in flight
+	// at tick deadline is finished within it
+	RequestTimeout time.Duration
+	// CallbackTimeout is used in push mode: only orders which status was not changed
+	// by accrual system callback within CallbackTimeout are polled (0 - poll all orders)
+	CallbackTimeout time.Duration
+	// ID identifies processor in order leases (generated if empty)
+	ID      string
+	limiter *limiter
+	mu      sync.Mutex
+	// rate is a current limit of requests per minute (0 - no limit)
+	rate int
 }
 
-func (p Processor) Start() {
+func (p *Processor) Start() {
 	log.Println("INFO processor have started")
 	defer p.Wg.Done()
+	if p.Workers < 1 {
+		p.Workers = 1
+	}
+	if p.ID == "" {
+		p.ID = newID()
+	}
+	log.Printf("INFO processor id: %s", p.ID)
+	p.rate = p.RateLimit
+	p.limiter = newLimiter(p.rate)
 	ticker := time.NewTicker(p.Delay)
+
+	// new orders are processed immediately in poll mode,
+	// ticker is a safety net for lost notifications
+	var newOrders <-chan int
+	if p.CallbackTimeout == 0 {
+		var err error
+		newOrders, err = p.Storage.SubscribeOrders(p.Ctx)
+		if err != nil {
+			log.Printf("ERROR processor failed to subscribe to new orders: %s. "+
+				"New orders will be processed on tick", err.Error())
+		}
+	}
+
 	for {
 		select {
 		case <-p.Ctx.Done():
 			log.Println("INFO processor has received  a ctx.Done()'. Exiting...")
+			p.release()
 			return
 		case <-ticker.C:
 			p.processOrders()
+		case id, ok := <-newOrders:
+			if !ok {
+				newOrders = nil
+				continue
+			}
+			p.processNewOrder(id)
 		}
 	}
 }
 
-func (p Processor) processOrders() {
+// paused reports whether requests to accrual system are paused
+func (p *Processor) paused() bool {
+	if p.Accrual.State() == structs.BreakerOpen {
+		log.Println("INFO processor accrual system is unavailable (circuit breaker is open). Skipping")
+		return true
+	}
+	if until := p.limiter.PausedUntil(); time.Now().Before(until) {
+		log.Printf("INFO processor accrual system requests are paused until %s. Skipping",
+			until.Format(time.RFC3339))
+		return true
+	}
+	return false
+}
+
+func (p *Processor) processOrders() {
 	log.Println("INFO processor starting process orders")
-	log.Println("INFO processor getting list of unprocessed orders")
-	orders, err := p.Storage.GetUnprocessedOrders()
+	if p.paused() {
+		return
+	}
+	log.Println("INFO processor claiming unprocessed orders")
+	orders, err := p.Storage.ClaimOrders(p.ID, p.Workers*ordersPerWorker, p.lease(), p.CallbackTimeout)
 	if err != nil {
-		log.Printf("ERROR processor failed to get list of orders: %s",
+		log.Printf("ERROR processor failed to claim orders: %s",
 			err.Error())
 		return
 	}
+	defer p.release()
 	if len(orders) == 0 {
 		log.Printf("INFO processor there are no unprocessed orders. Sleeping for %s", p.Delay)
+		return
 	}
 	log.Printf("INFO processor received %d unprocessed orders. Begin proccessing.", len(orders))
+	p.process(orders)
+	log.Printf("INFO processor finished processing orders. Sleeping for %s", p.Delay)
+}
+
+// lease returns lease duration of claimed orders. Lease outlives the tick deadline and
+// requests in flight, so orders are not claimed by another processor while they are processed
+func (p *Processor) lease() time.Duration {
+	return leaseFactor*p.Delay + p.RequestTimeout
+}
+
+// processNewOrder processes order right after it was uploaded.
+// Order is skipped if it was already claimed (e.g. by another processor)
+func (p *Processor) processNewOrder(id int) {
+	log.Printf("INFO processor received new order %d notification", id)
+	if p.paused() {
+		return
+	}
+	ok, err := p.Storage.ClaimOrder(p.ID, id, p.lease())
+	if err != nil {
+		log.Printf("ERROR processor failed to claim order %d: %s", id, err.Error())
+		return
+	}
+	if !ok {
+		log.Printf("INFO processor order %d is already claimed", id)
+		return
+	}
+	defer p.release()
+	p.process([]int{id})
+}
+
+// process processes claimed orders by workers. Orders are dispatched within a tick deadline,
+// requests which are in flight at the deadline are finished (they are limited by accrual client timeout)
+func (p *Processor) process(orders []int) {
+	// ticks must not overlap, undispatched orders will be picked up on the next tick
+	ctx, cancel := context.WithTimeout(p.Ctx, p.Delay)
+	defer cancel()
+
+	workers := p.Workers
+	if len(orders) < workers {
+		workers = len(orders)
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go p.worker(ctx, jobs, &wg)
+	}
+
+loop:
 	for _, o := range orders {
-		log.Printf("INFO processor processing order %d", o)
-		err := p.processOrder(o)
-		if err != nil {
-			if errors.Is(err, structs.ErrToManyRequests) {
-				log.Println("ERROR processor received to many requests from accrual system. " +
-					"Stopping order processing")
-				break
-			}
-			log.Printf("ERROR processor failed to process order %d: %s", o, err.Error())
+		select {
+		case jobs <- o:
+		case <-ctx.Done():
+			break loop
 		}
-		log.Printf("INFO processor finished processing order %d", o)
 	}
-	log.Printf("INFO processor finished processing orders. Sleeping for %s", p.Delay)
+	close(jobs)
+	wg.Wait()
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) && p.Ctx.Err() == nil {
+		log.Printf("WARN processor tick deadline (%s) exceeded, "+
+			"remaining orders will be processed on the next tick", p.Delay)
+	}
 }
 
-func (p Processor) processOrder(id int) error {
-	order, err := p.GetOrderAccrual(id)
+// release releases orders claimed by processor
+func (p *Processor) release() {
+	count, err := p.Storage.ReleaseOrders(p.ID)
 	if err != nil {
-		return err
+		log.Printf("ERROR processor failed to release orders: %s", err.Error())
+		return
 	}
-	log.Printf("INFO processor received order status from accrual: %v", order)
-	if order.Status == "INVALID" || order.Status == "PROCESSED" {
-		log.Printf("INFO proccessor updating order status "+
-			"(PROCESSING -> %s)", order.Status)
-		rowsAffected, err := p.Storage.SetOrderStatus(id, order.Status)
-		if err != nil {
-			e := fmt.Sprintf("ERROR processor failed to update order status: %s", err.Error())
-			return errors.New(e)
+	if count > 0 {
+		log.Printf("INFO processor released %d orders", count)
+	}
+}
+
+// worker processes orders from jobs until it is closed. Orders received after tick deadline are skipped,
+// but order which is already being processed is finished after the deadline
+func (p *Processor) worker(tick context.Context, jobs <-chan int, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for o := range jobs {
+		if tick.Err() != nil {
+			continue
 		}
-		if rowsAffected != 1 {
-			e := fmt.Sprintf("ERROR processor failed to update order status: "+
-				"invalid number of affected rows: %d", rowsAffected)
-			return errors.New(e)
+		if err := p.limiter.Wait(tick); err != nil {
+			continue
 		}
-	}
-	if order.Status == "PROCESSED" && order.Accrual != nil {
-		log.Printf("INFO proccessor setting order`s accural value (%f)",
-			*order.Accrual)
-		rowsAffected, err := p.Storage.SetOrderAccrual(id, *order.Accrual)
+		log.Printf("INFO processor processing order %d", o)
+		err := p.processOrder(p.Ctx, o)
 		if err != nil {
-			e := fmt.Sprintf("ERROR processor failed to update order accural: %s", err.Error())
-			return errors.New(e)
-		}
-		if rowsAffected != 1 {
-			e := fmt.Sprintf("ERROR processor failed to update order accrual: "+
-				"invalid number of affected rows: %d", rowsAffected)
-			return errors.New(e)
+			var rateErr *structs.RateLimitError
+			if errors.As(err, &rateErr) {
+				p.throttle(rateErr)
+				continue
+			}
+			if p.Ctx.Err() != nil {
+				// shutdown, order will be processed after restart
+				continue
+			}
+			if errors.Is(err, structs.ErrAccrualUnavailable) {
+				// order is not at fault, so its attempts are not counted
+				log.Printf("ERROR processor failed to process order %d: %s", o, err.Error())
+				continue
+			}
+			if errors.Is(err, structs.ErrOrderNotRegistered) {
+				log.Printf("INFO processor order %d is not registered in accrual system yet", o)
+				p.postpone(o, err)
+				continue
+			}
+			log.Printf("ERROR processor failed to process order %d: %s", o, err.Error())
+			p.retry(o, err)
+			continue
 		}
+		log.Printf("INFO processor finished processing order %d", o)
+	}
+}
+
+// throttle pauses all requests to accrual system until rate limit window expires
+// and adapts request rate to the limit advertised by accrual system
+func (p *Processor) throttle(e *structs.RateLimitError) {
+	log.Printf("WARN processor received to many requests from accrual system. "+
+		"Pausing requests until %s", e.RetryAfter.Format(time.RFC3339))
+	p.limiter.PauseUntil(e.RetryAfter)
+	if e.Limit == 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.rate == 0 || e.Limit < p.rate {
+		log.Printf("WARN processor limiting requests to accrual system to %d per minute", e.Limit)
+		p.rate = e.Limit
+		p.limiter.SetRate(e.Limit)
 	}
-	return nil
 }
 
-func (p Processor) GetOrderAccrual(orderID int) (structs.Order, error) {
-	url := fmt.Sprintf("%s/api/orders/%d", p.Accrual, orderID)
-	resp, err := http.Get(url)
+// retry schedules the next attempt to process order with exponential backoff
+func (p *Processor) retry(id int, procErr error) {
+	backoff := structs.Backoff{Base: p.Delay, Max: p.MaxBackoff, MaxAttempts: p.MaxAttempts}
+	attempts, dead, err := p.Storage.RetryOrder(id, procErr.Error(), backoff)
 	if err != nil {
-		return structs.Order{}, err
+		log.Printf("ERROR processor failed to schedule retry of order %d: %s", id, err.Error())
+		return
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode == http.StatusTooManyRequests {
-		return structs.Order{}, structs.ErrToManyRequests
+	if dead {
+		log.Printf("ERROR processor order %d failed %d times and was moved to dead-letter queue",
+			id, attempts)
+		return
 	}
-	var order structs.Order
-	err = json.NewDecoder(resp.Body).Decode(&order)
+	log.Printf("WARN processor order %d failed %d times, it will be retried later", id, attempts)
+}
+
+// postpone schedules the next attempt to process order which is not at fault
+// (e.g. it is not registered in accrual system yet), so its attempts are not counted
+func (p *Processor) postpone(id int, reason error) {
+	backoff := structs.Backoff{Base: p.Delay, Max: p.MaxBackoff}
+	err := p.Storage.PostponeOrder(id, reason.Error(), backoff.Delay(1))
 	if err != nil {
-		return structs.Order{}, err
+		log.Printf("ERROR processor failed to postpone order %d: %s", id, err.Error())
 	}
-	// round to 5 digits
-	var fixed float64
-	if order.Accrual != nil {
-		fixed = roundFloat(*order.Accrual, 5)
-		order.Accrual = &fixed
+}
+
+func (p *Processor) processOrder(ctx context.Context, id int) error {
+	order, err := p.Accrual.GetOrder(ctx, id)
+	if err != nil {
+		return err
 	}
-	return order, nil
+	return ApplyOrder(p.Storage, id, order, structs.SourceProcessor)
 }
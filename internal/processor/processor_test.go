@@ -0,0 +1,223 @@
+package processor_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zklevsha/go-musthave-diploma/internal/accrual"
+	"github.com/zklevsha/go-musthave-diploma/internal/config"
+	"github.com/zklevsha/go-musthave-diploma/internal/emulator"
+	"github.com/zklevsha/go-musthave-diploma/internal/handler"
+	"github.com/zklevsha/go-musthave-diploma/internal/memstorage"
+	"github.com/zklevsha/go-musthave-diploma/internal/processor"
+	"github.com/zklevsha/go-musthave-diploma/internal/structs"
+)
+
+const testOrder = 12345678903
+
+// newTestProcessor creates processor with in-memory storage containing one NEW order
+// and mock accrual server running scenarios (no scenarios - order is not registered)
+func newTestProcessor(t *testing.T, scenarios string) (*processor.Processor, *memstorage.MemStorage, int, *int64) {
+	t.Helper()
+	e := emulator.New()
+	if scenarios != "" {
+		s, err := emulator.ParseScenarios([]byte(scenarios))
+		if err != nil {
+			t.Fatalf("failed to parse scenarios: %s", err.Error())
+		}
+		e.SetScenarios(s)
+	}
+	accrualHandler := handler.AccGetHandler(config.AccrualConfig{}, context.Background(), e)
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		accrualHandler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	s := &memstorage.MemStorage{}
+	if err := s.Init(); err != nil {
+		t.Fatalf("failed to init storage: %s", err.Error())
+	}
+	t.Cleanup(s.Close)
+	userid, err := s.Register("user", "password")
+	if err != nil {
+		t.Fatalf("failed to register user: %s", err.Error())
+	}
+	if _, err := s.CreateOrder(userid, testOrder); err != nil {
+		t.Fatalf("failed to create order: %s", err.Error())
+	}
+
+	client, err := accrual.NewHTTPClient(accrual.Config{
+		URL:                srv.URL,
+		Timeout:            time.Second,
+		BreakerThreshold:   2,
+		BreakerCooldown:    time.Minute,
+		BreakerMaxCooldown: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("failed to create accrual client: %s", err.Error())
+	}
+	p := &processor.Processor{
+		Delay:          time.Minute,
+		Ctx:            context.Background(),
+		Storage:        s,
+		Accrual:        client,
+		Workers:        1,
+		MaxBackoff:     time.Hour,
+		RequestTimeout: time.Second,
+		ID:             "test",
+	}
+	return p, s, userid, &hits
+}
+
+func orderStatusOf(t *testing.T, s *memstorage.MemStorage, userid int) string {
+	t.Helper()
+	orders, _, err := s.GetOrders(userid, structs.ListFilter{})
+	if err != nil {
+		t.Fatalf("failed to get orders: %s", err.Error())
+	}
+	if len(orders) != 1 {
+		t.Fatalf("want 1 order, got %d", len(orders))
+	}
+	return orders[0].Status
+}
+
+func TestProcessNotRegisteredOrder(t *testing.T) {
+	p, s, userid, _ := newTestProcessor(t, "")
+	p.MaxAttempts = 3
+
+	// order which is not registered yet is not at fault, so it is never dead-lettered
+	for i := 0; i < 2*p.MaxAttempts; i++ {
+		p.Process([]int{testOrder})
+	}
+
+	if status := orderStatusOf(t, s, userid); status != "NEW" {
+		t.Errorf("want NEW order, got %s", status)
+	}
+	// retry is scheduled after backoff, so order is not due for processing now
+	orders, err := s.ClaimOrders("other", 10, time.Minute, 0)
+	if err != nil {
+		t.Fatalf("failed to claim orders: %s", err.Error())
+	}
+	if len(orders) != 0 {
+		t.Errorf("order retry is not scheduled, claimed: %v", orders)
+	}
+	dead, err := s.GetDeadOrders()
+	if err != nil {
+		t.Fatalf("failed to get dead orders: %s", err.Error())
+	}
+	if len(dead) != 0 {
+		t.Errorf("order must not be dead-lettered: %+v", dead)
+	}
+}
+
+func TestProcessUnavailableAccrualPausesProcessor(t *testing.T) {
+	p, s, userid, hits := newTestProcessor(t, `{"scenarios": [
+		{"order": "12345678903", "responses": [{"code": 503}]}]}`)
+
+	if p.Paused() {
+		t.Fatal("processor is paused before failures")
+	}
+	p.Process([]int{testOrder})
+	p.Process([]int{testOrder})
+	if !p.Paused() {
+		t.Fatal("processor is not paused after repeated 5xx")
+	}
+
+	// paused processor does not claim orders
+	p.ProcessOrders()
+	if n := atomic.LoadInt64(hits); n != 2 {
+		t.Errorf("want 2 requests to accrual system, got %d", n)
+	}
+	if status := orderStatusOf(t, s, userid); status != "NEW" {
+		t.Errorf("want NEW order, got %s", status)
+	}
+	// order is not at fault, so retry is not scheduled
+	orders, err := s.ClaimOrders("other", 10, time.Minute, 0)
+	if err != nil {
+		t.Fatalf("failed to claim orders: %s", err.Error())
+	}
+	if len(orders) != 1 {
+		t.Errorf("want order to be due for processing, claimed: %v", orders)
+	}
+}
+
+func TestProcessRegisteredOrder(t *testing.T) {
+	p, s, userid, _ := newTestProcessor(t, `{"scenarios": [
+		{"order": "12345678903", "responses": [{"status": "REGISTERED"}]}]}`)
+
+	p.Process([]int{testOrder})
+
+	if status := orderStatusOf(t, s, userid); status != "PROCESSING" {
+		t.Errorf("want PROCESSING order, got %s", status)
+	}
+	events, err := s.GetOrderHistory(userid, testOrder)
+	if err != nil {
+		t.Fatalf("failed to get order history: %s", err.Error())
+	}
+	last := events[len(events)-1]
+	if last.From != "NEW" || last.To != "PROCESSING" || last.Source != structs.SourceProcessor {
+		t.Errorf("unexpected order event: %+v", last)
+	}
+}
+
+func TestProcessProcessedOrder(t *testing.T) {
+	p, s, userid, _ := newTestProcessor(t, `{"scenarios": [
+		{"order": "12345678903", "responses": [{"status": "PROCESSED", "accrual": 500}]}]}`)
+
+	p.Process([]int{testOrder})
+	p.Process([]int{testOrder})
+
+	if status := orderStatusOf(t, s, userid); status != "PROCESSED" {
+		t.Errorf("want PROCESSED order, got %s", status)
+	}
+	balance, err := s.GetUserBalance(userid)
+	if err != nil {
+		t.Fatalf("failed to get balance: %s", err.Error())
+	}
+	if balance.Current.String() != "500" {
+		t.Errorf("accrual must be credited once, balance: %s", balance.Current)
+	}
+}
+
+func TestProcessSlowAccrual(t *testing.T) {
+	p, s, userid, _ := newTestProcessor(t, `{"scenarios": [
+		{"order": "12345678903", "responses": [{"status": "PROCESSED", "accrual": 500, "delay": "100ms"}]}]}`)
+	// request in flight at tick deadline is finished
+	p.Delay = 20 * time.Millisecond
+
+	p.Process([]int{testOrder})
+
+	if status := orderStatusOf(t, s, userid); status != "PROCESSED" {
+		t.Errorf("want PROCESSED order, got %s", status)
+	}
+}
+
+func TestApplyOrderUnknownStatus(t *testing.T) {
+	_, s, userid, _ := newTestProcessor(t, "")
+
+	order := structs.Order{Order: "12345678903", Status: "LOST"}
+	err := processor.ApplyOrder(s, testOrder, order, structs.SourceProcessor)
+	if !errors.Is(err, structs.ErrUnknownOrderStatus) {
+		t.Fatalf("want ErrUnknownOrderStatus, got %v", err)
+	}
+	if status := orderStatusOf(t, s, userid); status != "NEW" {
+		t.Errorf("want NEW order, got %s", status)
+	}
+}
+
+func TestApplyOrderNotFound(t *testing.T) {
+	_, s, _, _ := newTestProcessor(t, "")
+
+	order := structs.Order{Order: "79927398713", Status: "PROCESSING"}
+	err := processor.ApplyOrder(s, 79927398713, order, structs.SourceProcessor)
+	if !errors.Is(err, structs.ErrOrderNotFound) {
+		t.Fatalf("want ErrOrderNotFound, got %v", err)
+	}
+}
@@ -1,6 +1,8 @@
 package structs
 
+import "github.com/zklevsha/go-musthave-diploma/internal/money"
+
 type Balance struct {
-	Current   float64 `json:"current"`
-	Withdrawn float64 `json:"withdrawn"`
+	Current   money.Amount `json:"current"`
+	Withdrawn money.Amount `json:"withdrawn"`
 }
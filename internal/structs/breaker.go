@@ -0,0 +1,8 @@
+package structs
+
+// States of circuit breaker
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
@@ -6,3 +6,15 @@ var ErrUserAlreadyExists = errors.New("user already exists")
 var ErrUserAuth = errors.New("authentication failed")
 var ErrOrderIDAlreadyUsed = errors.New("order id already used by another user")
 var ErrToManyRequests = errors.New("to many request to the remote system")
+var ErrInsufficientFunds = errors.New("insufficient funds")
+var ErrIdempotencyKeyLost = errors.New("idempotency key reservation was taken over by another request")
+var ErrLedgerEntryNotFound = errors.New("ledger entry not found")
+var ErrLedgerEntryReversed = errors.New("ledger entry already reversed")
+var ErrOrderNotFound = errors.New("order not found")
+var ErrOrderStatusFinal = errors.New("order status is final and can not be changed")
+var ErrOrderNotRegistered = errors.New("order is not registered in the remote system")
+var ErrUnknownOrderStatus = errors.New("unknown order status")
+var ErrAccrualUnavailable = errors.New("remote system is unavailable")
+var ErrRefreshTokenInvalid = errors.New("refresh token is invalid or expired")
+var ErrRefreshTokenReused = errors.New("refresh token was already used, all tokens of the session are revoked")
+var ErrTokenRevoked = errors.New("token is revoked")
@@ -0,0 +1,20 @@
+package structs
+
+import "time"
+
+// IdempotencyLockTimeout is a time after which reservation of unfinished request
+// is considered abandoned (e.g. server crashed), so the request can be retried
+const IdempotencyLockTimeout = time.Minute
+
+// IdempotencyKeyTTL is a time responses of finished requests are kept for replay
+const IdempotencyKeyTTL = 24 * time.Hour
+
+type IdempotencyRecord struct {
+	Key         string
+	RequestHash string
+	// StatusCode is 0 while the first request is in progress
+	StatusCode int
+	Response   []byte
+	// CreatedAt identifies reservation: only its owner can store response or release the key
+	CreatedAt time.Time
+}
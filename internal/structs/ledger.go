@@ -0,0 +1,23 @@
+package structs
+
+import "github.com/zklevsha/go-musthave-diploma/internal/money"
+
+// Ledger entry types
+const (
+	// LedgerAccrual credits order accrual. Reference is order number
+	LedgerAccrual = "ACCRUAL"
+	// LedgerWithdrawal debits withdrawn sum. Reference is order number
+	LedgerWithdrawal = "WITHDRAWAL"
+	// LedgerReversal cancels another entry. Reference is id of reversed entry
+	LedgerReversal = "REVERSAL"
+	// LedgerAdjustment is a manual correction. Reference is a reason of adjustment
+	LedgerAdjustment = "ADJUSTMENT"
+)
+
+type LedgerEntry struct {
+	ID        int64        `json:"id"`
+	Type      string       `json:"type"`
+	Amount    money.Amount `json:"amount"`
+	Reference string       `json:"reference"`
+	CreatedAt string       `json:"created_at,omitempty"`
+}
@@ -0,0 +1,47 @@
+package structs
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+var ErrBadCursor = errors.New("bad cursor")
+
+// Cursor points to the last item of the returned page
+type Cursor struct {
+	TS int64
+	ID int64
+}
+
+// Encode returns opaque string representation of the cursor
+func (c Cursor) Encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", c.TS, c.ID)))
+}
+
+func DecodeCursor(s string) (Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrBadCursor
+	}
+	var c Cursor
+	if _, err := fmt.Sscanf(string(b), "%d:%d", &c.TS, &c.ID); err != nil {
+		return Cursor{}, ErrBadCursor
+	}
+	return c, nil
+}
+
+// ListFilter describes requested page of orders or withdrawals
+type ListFilter struct {
+	// Limit is a maximum number of returned items (0 - no limit)
+	Limit int
+	// After is a cursor of the previous page
+	After *Cursor
+	// Status filters orders by status ("" - any status)
+	Status string
+	// From and To are unix timestamps ([From, To), 0 - no bound)
+	From int64
+	To   int64
+	// Desc sorts newest items first
+	Desc bool
+}
@@ -1,9 +1,18 @@
 package structs
 
+import "github.com/zklevsha/go-musthave-diploma/internal/money"
+
 type Order struct {
-	Number     string   `json:"number,omitempty"`
-	Order      string   `json:"order,omitempty"`
-	Status     string   `json:"status"`
-	Accrual    *float64 `json:"accrual,omitempty"`
-	UploadedAt string   `json:"uploaded_at,omitempty"`
+	Number     string        `json:"number,omitempty"`
+	Order      string        `json:"order,omitempty"`
+	Status     string        `json:"status"`
+	Accrual    *money.Amount `json:"accrual,omitempty"`
+	UploadedAt string        `json:"uploaded_at,omitempty"`
+	// Raw is a raw response of accrual system
+	Raw []byte `json:"-"`
+}
+
+// IsFinalStatus reports whether order status can not be changed anymore
+func IsFinalStatus(status string) bool {
+	return status == "INVALID" || status == "PROCESSED"
 }
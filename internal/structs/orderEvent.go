@@ -0,0 +1,36 @@
+package structs
+
+import "encoding/json"
+
+// Sources of order status changes
+const (
+	SourceUser      = "user"
+	SourceProcessor = "processor"
+	SourceAdmin     = "admin"
+	// SourceCallback is a result pushed by accrual system
+	SourceCallback = "callback"
+)
+
+// RequeuePayload is a payload of event recorded when admin requeues dead-lettered order
+var RequeuePayload = json.RawMessage(`{"action":"requeue"}`)
+
+type OrderEvent struct {
+	From   string `json:"from,omitempty"`
+	To     string `json:"to"`
+	Source string `json:"source"`
+	// Payload is a raw response of accrual system
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	CreatedAt string          `json:"created_at"`
+}
+
+// EventPayload converts raw response to a valid json value
+func EventPayload(raw []byte) json.RawMessage {
+	if len(raw) == 0 {
+		return nil
+	}
+	if json.Valid(raw) {
+		return raw
+	}
+	quoted, _ := json.Marshal(string(raw))
+	return quoted
+}
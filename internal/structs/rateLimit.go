@@ -0,0 +1,28 @@
+package structs
+
+import (
+	"fmt"
+	"time"
+)
+
+// RateLimitError is returned when accrual system responds with 429.
+// It wraps ErrToManyRequests
+type RateLimitError struct {
+	// RetryAfter is a time after which requests are allowed again
+	RetryAfter time.Time
+	// Limit is a number of requests per minute advertised by accrual system (0 - unknown)
+	Limit int
+}
+
+func (e *RateLimitError) Error() string {
+	msg := fmt.Sprintf("%s: retry after %s", ErrToManyRequests.Error(),
+		e.RetryAfter.Format(time.RFC3339))
+	if e.Limit > 0 {
+		msg += fmt.Sprintf(", limit %d requests per minute", e.Limit)
+	}
+	return msg
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return ErrToManyRequests
+}
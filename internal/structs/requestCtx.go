@@ -2,3 +2,4 @@ package structs
 
 type RequestCtxUserID struct{}
 type RequestCtxBody struct{}
+type RequestCtxClaims struct{}
@@ -0,0 +1,46 @@
+package structs
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff is a policy of order processing retries
+type Backoff struct {
+	// Base is a delay after the first failed attempt
+	Base time.Duration
+	// Max is a maximum delay between attempts
+	Max time.Duration
+	// MaxAttempts is a number of failed attempts after which order is dead-lettered (0 - unlimited)
+	MaxAttempts int
+}
+
+// Delay returns exponential delay with jitter after attempt failed attempts.
+// Result is a random value in [d/2, d), where d = min(Base * 2^(attempt-1), Max)
+func (b Backoff) Delay(attempt int) time.Duration {
+	d := b.Base
+	for i := 1; i < attempt && (b.Max <= 0 || d < b.Max); i++ {
+		d *= 2
+	}
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// Dead reports whether order must be dead-lettered after attempt failed attempts
+func (b Backoff) Dead(attempt int) bool {
+	return b.MaxAttempts > 0 && attempt >= b.MaxAttempts
+}
+
+// DeadOrder is an order which processing was stopped after too many failed attempts
+type DeadOrder struct {
+	Number    string `json:"number"`
+	Status    string `json:"status"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error"`
+	DeadAt    string `json:"dead_at"`
+}
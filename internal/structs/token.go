@@ -0,0 +1,29 @@
+package structs
+
+import "time"
+
+// RefreshToken is a stored refresh token. Token itself is not stored, only its hash.
+// Tokens issued one instead of another (rotated) belong to the same family
+type RefreshToken struct {
+	Hash      string
+	UserID    int
+	Family    string
+	ExpiresAt time.Time
+	// AccessID and AccessExpiresAt identify access token issued together with refresh token,
+	// it is revoked with the family
+	AccessID        string
+	AccessExpiresAt time.Time
+}
+
+// TokenResponse is sent when user receives new tokens
+type TokenResponse struct {
+	Message      string `json:"message,omitempty"`
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
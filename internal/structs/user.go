@@ -0,0 +1,7 @@
+package structs
+
+type User struct {
+	ID       int
+	Login    string
+	Password string
+}
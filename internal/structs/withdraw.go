@@ -1,7 +1,9 @@
 package structs
 
+import "github.com/zklevsha/go-musthave-diploma/internal/money"
+
 type Withdraw struct {
-	Order       string  `json:"order"`
-	Sum         float64 `json:"sum"`
-	ProcessedAt string  `json:"processed_at,omitempty"`
+	Order       string       `json:"order"`
+	Sum         money.Amount `json:"sum"`
+	ProcessedAt string       `json:"processed_at,omitempty"`
 }